@@ -0,0 +1,163 @@
+// wechatpay-notify 微信支付回调通知及签名排查工具
+//
+// 回放存档的历史通知，存档由 NotifyMux.Archive 写入，回放地址为服务中挂载 NotifyMux.ReplayHandler 的内网地址：
+//
+//	WECHATPAY_REPLAY_SECRET=... wechatpay-notify replay -archive notify.jsonl -url http://127.0.0.1:8080/internal/wechatpay/replay \
+//		-event-type REFUND. -since 2021-04-01T00:00:00+08:00
+//
+// 回放请求使用与 NotifyMux.ReplayHandler 共享的 secret 签名，secret 从环境变量 WECHATPAY_REPLAY_SECRET 或 -secret-file 读取
+//
+// 使用密钥材料重新校验签名，诊断信息为开启 DebugSignature 后 core.SignatureError 中 Diagnostics 的json：
+//
+//	wechatpay-notify check-signature -diagnostics sign_error.json -cert apiclient_cert.pem -key apiclient_key.pem
+//	wechatpay-notify check-signature -archive notify.jsonl -id EV-2018022511223320873 -platform-cert wechatpay.pem
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "replay":
+		err = replay(os.Args[2:])
+	case "check-signature":
+		err = checkSignature(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: wechatpay-notify replay -archive <file> -url <replay url> [flags]")
+	fmt.Fprintln(os.Stderr, "       wechatpay-notify check-signature (-diagnostics <file> | -archive <file> -id <id>) [flags]")
+}
+
+// replay 把存档中符合条件的通知逐条发送到回放地址
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	archivePath := fs.String("archive", "", "通知存档文件")
+	replayURL := fs.String("url", "", "服务中 NotifyMux.ReplayHandler 的地址")
+	id := fs.String("id", "", "只回放指定通知ID")
+	eventType := fs.String("event-type", "", "只回放指定通知类型，以 . 结尾时匹配前缀，如 REFUND.")
+	since := fs.String("since", "", "只回放此时间之后收到的通知，RFC3339格式")
+	until := fs.String("until", "", "只回放此时间之前收到的通知，RFC3339格式")
+	dryRun := fs.Bool("dry-run", false, "只列出要回放的通知，不发送")
+	secretFile := fs.String("secret-file", "", "回放签名的 secret 文件，为空时使用环境变量 WECHATPAY_REPLAY_SECRET")
+	_ = fs.Parse(args)
+	if *archivePath == "" || (*replayURL == "" && !*dryRun) {
+		fs.Usage()
+		return fmt.Errorf("archive and url are required")
+	}
+	secret := []byte(os.Getenv("WECHATPAY_REPLAY_SECRET"))
+	if *secretFile != "" {
+		data, err := ioutil.ReadFile(*secretFile)
+		if err != nil {
+			return err
+		}
+		secret = bytes.TrimSpace(data)
+	}
+	if len(secret) == 0 && !*dryRun {
+		return fmt.Errorf("replay secret is required")
+	}
+	var sinceTime, untilTime time.Time
+	var err error
+	if *since != "" {
+		if sinceTime, err = time.Parse(time.RFC3339, *since); err != nil {
+			return fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if *until != "" {
+		if untilTime, err = time.Parse(time.RFC3339, *until); err != nil {
+			return fmt.Errorf("invalid until: %v", err)
+		}
+	}
+	f, err := os.Open(*archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var replayed, failed int
+	err = core.ReadNotificationArchive(f, func(notification *core.ArchivedNotification) error {
+		var summary struct {
+			ID        string `json:"id"`
+			EventType string `json:"event_type"`
+		}
+		_ = json.Unmarshal([]byte(notification.Body), &summary)
+		if *id != "" && summary.ID != *id {
+			return nil
+		}
+		if *eventType != "" && summary.EventType != *eventType &&
+			!(strings.HasSuffix(*eventType, ".") && strings.HasPrefix(summary.EventType, *eventType)) {
+			return nil
+		}
+		if (!sinceTime.IsZero() && notification.ReceivedAt.Before(sinceTime)) ||
+			(!untilTime.IsZero() && notification.ReceivedAt.After(untilTime)) {
+			return nil
+		}
+		if *dryRun {
+			fmt.Printf("%s %s %s\n", notification.ReceivedAt.Format(time.RFC3339), summary.ID, summary.EventType)
+			return nil
+		}
+		replayed++
+		if err := send(*replayURL, secret, notification); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s %s: %v\n", summary.ID, summary.EventType, err)
+			return nil
+		}
+		fmt.Printf("%s %s replayed\n", summary.ID, summary.EventType)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notifications failed", failed, replayed)
+	}
+	return nil
+}
+
+// send 使用 secret 签名后发送一条存档记录到回放地址
+func send(replayURL string, secret []byte, notification *core.ArchivedNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, replayURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set(core.ContentType, core.ApplicationJSON)
+	core.SignReplayRequest(request.Header, secret, body, time.Now())
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slurp, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, slurp)
+	}
+	return nil
+}
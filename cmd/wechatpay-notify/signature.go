@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/util"
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+// checkSignature 使用密钥材料重新校验 core.SignatureError 中的签名诊断信息或存档通知的签名
+func checkSignature(args []string) error {
+	fs := flag.NewFlagSet("check-signature", flag.ExitOnError)
+	diagnosticsPath := fs.String("diagnostics", "", "core.SignatureDiagnostics 的json文件")
+	archivePath := fs.String("archive", "", "通知存档文件，与 -id 一起使用")
+	id := fs.String("id", "", "要校验的通知ID")
+	certPath := fs.String("cert", "", "商户证书 apiclient_cert.pem，校验请求签名")
+	keyPath := fs.String("key", "", "商户RSA或SM2私钥 apiclient_key.pem，检查私钥与证书是否匹配并重新签名")
+	platformCertPath := fs.String("platform-cert", "", "微信支付平台证书，校验回包及通知签名")
+	_ = fs.Parse(args)
+	if (*diagnosticsPath == "") == (*archivePath == "") || (*archivePath != "" && *id == "") {
+		fs.Usage()
+		return fmt.Errorf("one of diagnostics or archive with id is required")
+	}
+	var certificate, platformCertificate *x509.Certificate
+	var privateKey interface{}
+	var err error
+	if *certPath != "" {
+		if certificate, err = loadCertificate(*certPath); err != nil {
+			return err
+		}
+	}
+	if *keyPath != "" {
+		if privateKey, err = loadPrivateKey(*keyPath); err != nil {
+			return err
+		}
+	}
+	if *platformCertPath != "" {
+		if platformCertificate, err = loadCertificate(*platformCertPath); err != nil {
+			return err
+		}
+	}
+	if *archivePath != "" {
+		return checkArchivedNotification(*archivePath, *id, platformCertificate)
+	}
+	data, err := ioutil.ReadFile(*diagnosticsPath)
+	if err != nil {
+		return err
+	}
+	d := &core.SignatureDiagnostics{}
+	if err = json.Unmarshal(data, d); err != nil {
+		return fmt.Errorf("invalid diagnostics: %v", err)
+	}
+	failed := 0
+	if d.Authorization != "" {
+		failed += checkRequest(d, certificate, privateKey)
+	}
+	if d.VerifyMessage != "" {
+		failed += checkResponse(d, platformCertificate)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d signature checks failed", failed)
+	}
+	return nil
+}
+
+// loadCertificate 加载RSA或SM2证书
+func loadCertificate(path string) (*x509.Certificate, error) {
+	certificate, err := util.LoadCertificateWithPath(path)
+	if err != nil {
+		if sm2Certificate, sm2Err := util.LoadSM2CertificateWithPath(path); sm2Err == nil {
+			return sm2Certificate, nil
+		}
+	}
+	return certificate, err
+}
+
+// loadPrivateKey 加载RSA或SM2私钥，返回 *rsa.PrivateKey 或 *sm2.PrivateKey
+func loadPrivateKey(path string) (interface{}, error) {
+	privateKey, err := util.LoadPrivateKeyWithPath(path)
+	if err != nil {
+		if sm2Key, sm2Err := util.LoadSM2PrivateKeyWithPath(path); sm2Err == nil {
+			return sm2Key, nil
+		}
+		return nil, err
+	}
+	return privateKey, nil
+}
+
+// certificateSchema 证书公钥类型对应的认证类型
+func certificateSchema(certificate *x509.Certificate) string {
+	if _, ok := certificate.PublicKey.(*sm2.PublicKey); ok {
+		return core.SchemaSM2WithSM3
+	}
+	return core.SchemaSHA256RSA2048
+}
+
+// newVerifier 按证书公钥类型选择 WechatPayVerifier 或 SM2WithSM3Verifier
+func newVerifier(serialNo string, certificate *x509.Certificate) core.Verifier {
+	certificates := map[string]*x509.Certificate{serialNo: certificate}
+	if certificateSchema(certificate) == core.SchemaSM2WithSM3 {
+		return &core.SM2WithSM3Verifier{Certificates: certificates}
+	}
+	return &core.WechatPayVerifier{Certificates: certificates}
+}
+
+// keyMatches 私钥是否与证书匹配
+func keyMatches(certificate *x509.Certificate, privateKey interface{}) bool {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+		return ok && publicKey.Equal(&key.PublicKey)
+	case *sm2.PrivateKey:
+		publicKey, ok := certificate.PublicKey.(*sm2.PublicKey)
+		return ok && publicKey.X.Cmp(key.X) == 0 && publicKey.Y.Cmp(key.Y) == 0
+	}
+	return false
+}
+
+// checkResign 使用私钥检查请求签名：RSA签名是确定的，重新签名后比较；SM2签名带随机数，使用私钥对应的公钥验签
+func checkResign(d *core.SignatureDiagnostics, authorization *core.AuthorizationHeader, privateKey interface{}) error {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if authorization.Schema != core.SchemaSHA256RSA2048 {
+			return fmt.Errorf("authorization schema %s, private key is rsa", authorization.Schema)
+		}
+		signer := &core.SHA256WithRSASigner{MchCertificateSerialNo: authorization.SerialNo, PrivateKey: key}
+		result, err := signer.Sign(context.Background(), d.SignMessage)
+		if err != nil {
+			return err
+		}
+		if result.Signature != authorization.Signature {
+			return fmt.Errorf("signature differs from authorization, the request was signed with another key")
+		}
+	case *sm2.PrivateKey:
+		if authorization.Schema != core.SchemaSM2WithSM3 {
+			return fmt.Errorf("authorization schema %s, private key is sm2", authorization.Schema)
+		}
+		signature, err := base64.StdEncoding.DecodeString(authorization.Signature)
+		if err != nil {
+			return err
+		}
+		if !key.PublicKey.Verify([]byte(d.SignMessage), signature) {
+			return fmt.Errorf("signature does not match private key, the request was signed with another key")
+		}
+	}
+	return nil
+}
+
+// checkRequest 校验请求签名，返回失败的检查数
+func checkRequest(d *core.SignatureDiagnostics, certificate *x509.Certificate, privateKey interface{}) int {
+	fmt.Printf("request: %s %s\nsign message: %q\nauthorization: %s\n", d.Method, d.URL, d.SignMessage, d.Authorization)
+	authorization, err := core.ParseAuthorization(d.Authorization)
+	if err != nil {
+		fmt.Printf("request signature: FAIL %v\n", err)
+		return 1
+	}
+	failed := 0
+	if privateKey != nil && certificate != nil {
+		if !keyMatches(certificate, privateKey) {
+			fmt.Println("merchant key: FAIL private key does not match certificate")
+			failed++
+		} else {
+			fmt.Println("merchant key: OK")
+		}
+	}
+	if privateKey != nil {
+		if err = checkResign(d, authorization, privateKey); err != nil {
+			fmt.Printf("re-sign: FAIL %v\n", err)
+			failed++
+		} else {
+			fmt.Println("re-sign: OK")
+		}
+	}
+	if certificate == nil {
+		return failed
+	}
+	serialNo := util.GetCertificateSerialNumber(certificate)
+	if serialNo != authorization.SerialNo {
+		fmt.Printf("serial_no: FAIL authorization %s, certificate %s\n", authorization.SerialNo, serialNo)
+		failed++
+	}
+	if schema := certificateSchema(certificate); schema != authorization.Schema {
+		fmt.Printf("schema: FAIL authorization %s, certificate %s\n", authorization.Schema, schema)
+		failed++
+	}
+	signature, err := base64.StdEncoding.DecodeString(authorization.Signature)
+	if err == nil {
+		err = newVerifier(authorization.SerialNo, certificate).Verify(context.Background(), authorization.SerialNo,
+			d.SignMessage, string(signature))
+	}
+	if err != nil {
+		fmt.Printf("request signature: FAIL %v\n", err)
+		return failed + 1
+	}
+	fmt.Println("request signature: OK")
+	return failed
+}
+
+// checkResponse 使用平台证书校验回包签名，返回失败的检查数
+func checkResponse(d *core.SignatureDiagnostics, platformCertificate *x509.Certificate) int {
+	fmt.Printf("request-id: %s\nverify message: %q\n", d.RequestID, d.VerifyMessage)
+	if platformCertificate == nil {
+		return 0
+	}
+	failed := 0
+	if serialNo := util.GetCertificateSerialNumber(platformCertificate); serialNo != d.ResponseSerial {
+		fmt.Printf("response serial: FAIL response %s, platform certificate %s\n", d.ResponseSerial, serialNo)
+		failed++
+	}
+	signature, err := base64.StdEncoding.DecodeString(d.ResponseSignature)
+	if err == nil {
+		err = newVerifier(d.ResponseSerial, platformCertificate).Verify(context.Background(), d.ResponseSerial,
+			d.VerifyMessage, string(signature))
+	}
+	if err != nil {
+		fmt.Printf("response signature: FAIL %v\n", err)
+		return failed + 1
+	}
+	fmt.Println("response signature: OK")
+	return failed
+}
+
+// checkArchivedNotification 使用平台证书重新校验存档通知的签名，不检查时间戳
+func checkArchivedNotification(archivePath, id string, platformCertificate *x509.Certificate) error {
+	if platformCertificate == nil {
+		return fmt.Errorf("platform-cert is required")
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var found *core.ArchivedNotification
+	err = core.ReadNotificationArchive(f, func(notification *core.ArchivedNotification) error {
+		var summary struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal([]byte(notification.Body), &summary) == nil && summary.ID == id {
+			found = notification
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return fmt.Errorf("notification %s not found", id)
+	}
+	d := &core.SignatureDiagnostics{}
+	ctx := core.WithSignatureDiagnostics(core.WithReplay(context.Background()), d)
+	verifier := newVerifier(util.GetCertificateSerialNumber(platformCertificate), platformCertificate)
+	err = (&core.WechatPayValidator{Verifier: verifier}).Validate(ctx, []byte(found.Body), found.Header)
+	fmt.Printf("request-id: %s\nserial: %s\nverify message: %q\n", d.RequestID, d.ResponseSerial, d.VerifyMessage)
+	if err != nil {
+		fmt.Printf("notification signature: FAIL %v\n", err)
+		return fmt.Errorf("signature check failed")
+	}
+	fmt.Println("notification signature: OK")
+	return nil
+}
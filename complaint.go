@@ -15,7 +15,7 @@ import (
 	"github.com/perlyna/wechatpay/util"
 )
 
-const complaintsURL = "https://api.mch.weixin.qq.com/v3/merchant-service/complaints-v2"
+const complaintsURL = "/v3/merchant-service/complaints-v2"
 
 // ListComplaints 查询投诉单列表
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_11.shtml
@@ -31,22 +31,15 @@ func (p *WechatPay) ListComplaints(ctx context.Context, begin, end time.Time) ([
 
 	for offset := 0; offset < totalCount; offset += limit {
 		v.Set("offset", strconv.Itoa(offset))
-		body, err := core.Get(ctx, p.Client, p.credential, p.validator, complaintsURL+"?"+v.Encode())
+		body, err := p.client().Get(ctx, complaintsURL+"?"+v.Encode())
 		if err != nil {
 			return nil, err
 		}
 		reply := model.ComplaintReply{}
-		if err = json.Unmarshal(body, &reply); err != nil {
+		if err = p.client().Unmarshal(ctx, body, &reply); err != nil {
 			return complaints, err
 		}
-		for _, complaint := range reply.Complaints {
-			if complaint.PayerPhone != "" {
-				if plaintext, err := util.DecryptOAEP(complaint.PayerPhone, p.privateKey); err == nil {
-					complaint.PayerPhone = plaintext
-				}
-			}
-			complaints = append(complaints, complaint)
-		}
+		complaints = append(complaints, reply.Complaints...)
 		totalCount = reply.TotalCount
 	}
 	return complaints, nil
@@ -56,18 +49,14 @@ func (p *WechatPay) ListComplaints(ctx context.Context, begin, end time.Time) ([
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_13.shtml
 // 最新更新时间：2021.04.01
 func (p *WechatPay) GetComplaint(ctx context.Context, complaintID string) (complaint model.Complaint, err error) {
-	reqURL := "https://api.mch.weixin.qq.com/v3/merchant-service/complaints-v2/" + complaintID
-	body, err := core.Get(ctx, p.Client, p.credential, p.validator, reqURL)
+	reqURL := "/v3/merchant-service/complaints-v2/" + complaintID
+	ctx = core.WithEndpoint(ctx, "/v3/merchant-service/complaints-v2/{complaint_id}")
+	body, err := p.client().Get(ctx, reqURL)
 	if err != nil {
 		return complaint, err
 	}
 
-	err = json.Unmarshal(body, &complaint)
-	if complaint.PayerPhone != "" {
-		if plaintext, err := util.DecryptOAEP(complaint.PayerPhone, p.privateKey); err == nil {
-			complaint.PayerPhone = plaintext
-		}
-	}
+	err = p.client().Unmarshal(ctx, body, &complaint)
 	return complaint, err
 }
 
@@ -80,11 +69,12 @@ func (p *WechatPay) NegotiationHistorys(ctx context.Context, complaintID string)
 	limit := 50     // 分页大小
 
 	v.Set("limit", strconv.Itoa(limit))
-	reqURL := fmt.Sprintf(`https://api.mch.weixin.qq.com/v3/merchant-service/complaints-v2/%s/negotiation-historys`, complaintID)
+	reqURL := fmt.Sprintf(`/v3/merchant-service/complaints-v2/%s/negotiation-historys`, complaintID)
+	ctx = core.WithEndpoint(ctx, "/v3/merchant-service/complaints-v2/{complaint_id}/negotiation-historys")
 	historys := []model.NegotiationHistory{}
 	for offset := 0; offset < totalCount; offset += limit {
 		v.Set("offset", strconv.Itoa(offset))
-		body, err := core.Get(ctx, p.Client, p.credential, p.validator, reqURL+"?"+v.Encode())
+		body, err := p.client().Get(ctx, reqURL+"?"+v.Encode())
 		if err != nil {
 			return historys, err
 		}
@@ -101,11 +91,17 @@ func (p *WechatPay) NegotiationHistorys(ctx context.Context, complaintID string)
 // ParseComplaintNotify 解析投诉通知回调数据
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_16.shtml
 func ParseComplaintNotify(body []byte, apiv3Secret string) (model.ComplaintEvent, error) {
+	return parseComplaintNotify(body, func(algorithm, associatedData, nonce, ciphertext string) ([]byte, error) {
+		return util.DecryptResource(algorithm, apiv3Secret, associatedData, nonce, ciphertext)
+	})
+}
+
+func parseComplaintNotify(body []byte, decrypt func(algorithm, associatedData, nonce, ciphertext string) ([]byte, error)) (model.ComplaintEvent, error) {
 	ret := model.ComplaintEvent{}
 	if err := json.Unmarshal(body, &ret); err != nil {
 		return ret, err
 	}
-	rawComplaint, err := util.DecryptToByte(apiv3Secret, ret.Resource.AssociatedData,
+	rawComplaint, err := decrypt(ret.Resource.Algorithm, ret.Resource.AssociatedData,
 		ret.Resource.Nonce, ret.Resource.Ciphertext)
 	if err != nil {
 		return ret, err
@@ -114,18 +110,40 @@ func ParseComplaintNotify(body []byte, apiv3Secret string) (model.ComplaintEvent
 	return ret, err
 }
 
-// ParseComplaintNotify 解析投诉通知回调数据
+// ParseComplaintNotify 解析投诉通知回调数据，不校验通知签名，需要校验签名时使用 NotifyMux
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_16.shtml
-func (p *WechatPay) ParseComplaintNotify(r *http.Request) (model.ComplaintEvent, error) {
+func (p *WechatPay) ParseComplaintNotify(r *http.Request) (event model.ComplaintEvent, err error) {
+	ctx, span := p.startNotifySpan(r)
+	defer func() {
+		span.SetAttribute(core.AttrEventID, event.ID)
+		span.SetAttribute(core.AttrEventType, event.EventType)
+		core.EndSpan(span, err)
+	}()
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return model.ComplaintEvent{}, fmt.Errorf("读取请求内容失败 %w", err)
 	}
-	return ParseComplaintNotify(body, p.apiv3Secret)
+	event, err = parseComplaintNotify(body, func(algorithm, associatedData, nonce, ciphertext string) ([]byte, error) {
+		return p.decryptResource(ctx, algorithm, associatedData, nonce, ciphertext)
+	})
+	if err != nil {
+		p.log(ctx, core.LogError, "wechatpay notification parse failed", "id", event.ID,
+			"event_type", event.EventType, "error", err)
+		if p.Metrics != nil {
+			p.Metrics.NotificationProcessed(ctx, event.EventType, core.NotifyFailure)
+		}
+		return event, err
+	}
+	if p.Metrics != nil {
+		p.Metrics.NotificationProcessed(ctx, event.EventType, core.NotifySuccess)
+	}
+	p.log(ctx, core.LogInfo, "wechatpay notification parsed", "id", event.ID,
+		"event_type", event.EventType, "complaint_id", event.ComplaintID, "action_type", event.ActionType)
+	return event, nil
 }
 
 // complaintNotifyURL 投诉通知回调地址API
-const complaintNotifyURL = "https://api.mch.weixin.qq.com/v3/merchant-service/complaint-notifications"
+const complaintNotifyURL = "/v3/merchant-service/complaint-notifications"
 
 // complaintNotifyReq 投诉通知回调地址请求参数
 type complaintNotifyReq struct {
@@ -137,14 +155,14 @@ type complaintNotifyReq struct {
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_2.shtml
 func (p *WechatPay) CreateComplaintNotification(ctx context.Context, notifyURL string) (err error) {
 	reqBody := complaintNotifyReq{URL: notifyURL}
-	_, err = core.Post(ctx, p.Client, p.credential, p.validator, complaintNotifyURL, reqBody)
+	_, err = p.client().Post(ctx, complaintNotifyURL, reqBody)
 	return err
 }
 
 // GetComplaintNotification 查询投诉通知回调地址
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_3.shtml
 func (p *WechatPay) GetComplaintNotification(ctx context.Context) (notifyURL string, err error) {
-	body, err := core.Get(ctx, p.Client, p.credential, p.validator, complaintNotifyURL)
+	body, err := p.client().Get(ctx, complaintNotifyURL)
 	if err != nil {
 		return "", err
 	}
@@ -156,7 +174,7 @@ func (p *WechatPay) GetComplaintNotification(ctx context.Context) (notifyURL str
 // ComplaintNotifications 创建投诉通知回调地址
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_4.shtml
 func (p *WechatPay) UpdateComplaintNotification(ctx context.Context, notifyURL string) (err error) {
-	body, err := core.Put(ctx, p.Client, p.credential, p.validator, complaintNotifyURL, complaintNotifyReq{URL: notifyURL})
+	body, err := p.client().Put(ctx, complaintNotifyURL, complaintNotifyReq{URL: notifyURL})
 	if err != nil {
 		return err
 	}
@@ -173,18 +191,19 @@ func (p *WechatPay) UpdateComplaintNotification(ctx context.Context, notifyURL s
 // GetComplaintNotification 查询投诉通知回调地址
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_5.shtml
 func (p *WechatPay) DeleteComplaintNotification(ctx context.Context) (err error) {
-	_, err = core.Delete(ctx, p.Client, p.credential, p.validator, complaintNotifyURL, nil)
+	_, err = p.client().Delete(ctx, complaintNotifyURL, nil)
 	return err
 }
 
 // ComplaintResponse 提交回复
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_14.shtml
 func (p *WechatPay) ComplaintResponse(ctx context.Context, response model.ComplaintResponse) error {
-	reqURL := fmt.Sprintf(`https://api.mch.weixin.qq.com/v3/merchant-service/complaints-v2/%s/response`, response.ComplaintID)
+	reqURL := fmt.Sprintf(`/v3/merchant-service/complaints-v2/%s/response`, response.ComplaintID)
+	ctx = core.WithEndpoint(ctx, "/v3/merchant-service/complaints-v2/{complaint_id}/response")
 	if response.MchID == "" {
 		response.MchID = p.mchID
 	}
-	_, err := core.Post(ctx, p.Client, p.credential, p.validator, reqURL, response)
+	_, err := p.client().Post(ctx, reqURL, response)
 	return err
 }
 
@@ -196,7 +215,8 @@ type complaintCompleteReq struct {
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_15.shtml
 func (p *WechatPay) CompleteComplaint(ctx context.Context, complaintID string) error {
 	req := complaintCompleteReq{MchID: p.mchID}
-	reqURL := fmt.Sprintf(`https://api.mch.weixin.qq.com/v3/merchant-service/complaints-v2/%s/complete`, complaintID)
-	_, err := core.Post(ctx, p.Client, p.credential, p.validator, reqURL, req)
+	reqURL := fmt.Sprintf(`/v3/merchant-service/complaints-v2/%s/complete`, complaintID)
+	ctx = core.WithEndpoint(ctx, "/v3/merchant-service/complaints-v2/{complaint_id}/complete")
+	_, err := p.client().Post(ctx, reqURL, req)
 	return err
 }
@@ -0,0 +1,164 @@
+// 微信支付api v3 请求authorization解析及校验
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNonceReplayed 请求的随机字符串已经使用过，请求可能被重放
+var ErrNonceReplayed = errors.New("wechatpay: authorization nonce replayed")
+
+// AuthorizationHeader 解析后的请求 Authorization 信息，是 WechatPayCredentials 生成内容的逆过程
+type AuthorizationHeader struct {
+	Schema    string // 认证类型，如 WECHATPAY2-SHA256-RSA2048
+	MchID     string // 商户号
+	Nonce     string // 请求随机串
+	Timestamp int64  // 时间戳
+	SerialNo  string // 商户证书序列号
+	Signature string // Base64编码的签名值
+}
+
+// ParseAuthorization 解析请求header中的 Authorization
+func ParseAuthorization(authorization string) (*AuthorizationHeader, error) {
+	authorization = strings.TrimSpace(authorization)
+	idx := strings.IndexByte(authorization, ' ')
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid authorization: missing schema")
+	}
+	a := &AuthorizationHeader{Schema: authorization[:idx]}
+	if a.Schema != SchemaSHA256RSA2048 && a.Schema != SchemaSM2WithSM3 {
+		return nil, fmt.Errorf("invalid authorization: unsupported schema %s", a.Schema)
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(authorization[idx+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 || len(kv[1]) < 2 || kv[1][0] != '"' || kv[1][len(kv[1])-1] != '"' {
+			return nil, fmt.Errorf("invalid authorization: malformed parameter %q", param)
+		}
+		if _, ok := params[kv[0]]; ok {
+			return nil, fmt.Errorf("invalid authorization: duplicate parameter %s", kv[0])
+		}
+		params[kv[0]] = kv[1][1 : len(kv[1])-1]
+	}
+	for _, key := range []string{"mchid", "nonce_str", "timestamp", "serial_no", "signature"} {
+		if params[key] == "" {
+			return nil, fmt.Errorf("invalid authorization: empty %s", key)
+		}
+	}
+	timestamp, err := strconv.ParseInt(params["timestamp"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization: timestamp %s", params["timestamp"])
+	}
+	a.MchID, a.Nonce, a.Timestamp = params["mchid"], params["nonce_str"], timestamp
+	a.SerialNo, a.Signature = params["serial_no"], params["signature"]
+	return a, nil
+}
+
+// Message 使用 FormatMessage 重建请求的签名串
+func (a *AuthorizationHeader) Message(method, canonicalURL, body string) string {
+	return fmt.Sprintf(FormatMessage, method, canonicalURL, a.Timestamp, a.Nonce, body)
+}
+
+// String 格式化为 Authorization header
+func (a *AuthorizationHeader) String() string {
+	return fmt.Sprintf(HeaderAuthorizationFormat, a.Schema, a.MchID, a.Nonce, a.Timestamp, a.SerialNo, a.Signature)
+}
+
+// NonceCache 记录已使用的请求随机串，用于拒绝重放的请求，多实例部署时可以使用redis等共享存储实现
+type NonceCache interface {
+	// Use 标记 key 在 expire 之前已使用，已经使用过时返回 ErrNonceReplayed，now 为校验方时钟的当前时间
+	Use(ctx context.Context, key string, now, expire time.Time) error
+}
+
+// MemoryNonceCache 内存中的请求随机串记录，仅适用于单实例
+type MemoryNonceCache struct {
+	mu        sync.Mutex
+	used      map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryNonceCache 创建内存中的请求随机串记录
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{used: make(map[string]time.Time)}
+}
+
+// Use 标记 key 已使用，使用 now 判断记录是否过期，过期的记录每分钟清理一次
+func (c *MemoryNonceCache) Use(ctx context.Context, key string, now, expire time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.nextSweep) {
+		for k, t := range c.used {
+			if now.After(t) {
+				delete(c.used, k)
+			}
+		}
+		c.nextSweep = now.Add(time.Minute)
+	}
+	if t, ok := c.used[key]; ok && !now.After(t) {
+		return ErrNonceReplayed
+	}
+	c.used[key] = expire
+	return nil
+}
+
+// AuthorizationVerifier 校验入站请求的 Authorization，用于转发微信支付请求的网关及模拟服务
+//
+// 校验顺序为：解析、商户号、时间戳、签名、随机串重放，签名通过后才会记录随机串
+type AuthorizationVerifier struct {
+	Verifier Verifier      // 使用商户证书的验证器，如 WechatPayVerifier、SM2WithSM3Verifier
+	MchID    string        // 非空时要求 Authorization 中的商户号一致
+	Clock    Clock         // 校验时间戳的时钟，为空时使用系统时钟
+	MaxSkew  time.Duration // 请求时间戳与当前时间允许的最大偏差，为0时为5分钟
+	Nonces   NonceCache    // 已使用的随机串记录，为空时不检查重放
+}
+
+// VerifyRequest 校验请求的 Authorization，canonicalURL 为请求的路径及查询参数
+func (v *AuthorizationVerifier) VerifyRequest(ctx context.Context, r *http.Request, body []byte) (*AuthorizationHeader, error) {
+	return v.Verify(ctx, r.Method, r.URL.RequestURI(), string(body), r.Header.Get(Authorization))
+}
+
+// Verify 校验 Authorization 是否为商户对 method、canonicalURL 及 body 的有效签名
+func (v *AuthorizationVerifier) Verify(ctx context.Context, method, canonicalURL, body,
+	authorization string) (*AuthorizationHeader, error) {
+	if v.Verifier == nil {
+		return nil, fmt.Errorf("you must init AuthorizationVerifier with Verifier")
+	}
+	a, err := ParseAuthorization(authorization)
+	if err != nil {
+		return nil, err
+	}
+	if v.MchID != "" && a.MchID != v.MchID {
+		return a, fmt.Errorf("authorization mchid=%s mismatch", a.MchID)
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = FiveMinute * time.Second
+	}
+	current := now(v.Clock)
+	if math.Abs(float64(a.Timestamp-current.Unix())) >= maxSkew.Seconds() {
+		return a, fmt.Errorf("authorization timestamp=%d expires", a.Timestamp)
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return a, fmt.Errorf("base64 decode authorization signature err:%s", err.Error())
+	}
+	if err = v.Verifier.Verify(ctx, a.SerialNo, a.Message(method, canonicalURL, body), string(signature)); err != nil {
+		return a, fmt.Errorf("authorization verify fail mchid=%s serial=%s err=%s", a.MchID, a.SerialNo, err)
+	}
+	if v.Nonces != nil {
+		expire := time.Unix(a.Timestamp, 0).Add(maxSkew)
+		if err = v.Nonces.Use(ctx, a.MchID+":"+a.Nonce, current, expire); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
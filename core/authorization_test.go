@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAuthorizationVerifier(t *testing.T) {
+	ctx := context.Background()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &SHA256WithRSASigner{MchCertificateSerialNo: "SERIAL", PrivateKey: privateKey}
+	credential := &WechatPayCredentials{Signer: signer, MchID: "1900009191"}
+	body := `{"mchid":"1900009191"}`
+	header, err := credential.GenerateAuthorizationHeader(ctx, "POST", "/v3/pay/transactions/native", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseAuthorization(header)
+	if err != nil {
+		t.Fatalf("ParseAuthorization() error = %v", err)
+	}
+	if parsed.MchID != "1900009191" || parsed.SerialNo != "SERIAL" || parsed.String() != header {
+		t.Errorf("ParseAuthorization() = %+v", parsed)
+	}
+	if _, err = ParseAuthorization(SchemaSHA256RSA2048 + ` mchid="1900009191",mchid="1900009192"`); err == nil {
+		t.Errorf("ParseAuthorization() duplicate parameter should fail")
+	}
+
+	verifier := &AuthorizationVerifier{
+		Verifier: &WechatPayVerifier{Certificates: map[string]*x509.Certificate{"SERIAL": {PublicKey: &privateKey.PublicKey}}},
+		MchID:    "1900009191",
+		Nonces:   NewMemoryNonceCache(),
+	}
+	if _, err = verifier.Verify(ctx, "POST", "/v3/pay/transactions/native", body+" ", header); err == nil {
+		t.Errorf("Verify() tampered body should fail")
+	}
+	if _, err = verifier.Verify(ctx, "POST", "/v3/pay/transactions/native", body, header); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if _, err = verifier.Verify(ctx, "POST", "/v3/pay/transactions/native", body, header); !errors.Is(err, ErrNonceReplayed) {
+		t.Errorf("Verify() replay error = %v", err)
+	}
+	verifier.Clock = ClockFunc(func() time.Time { return time.Now().Add(10 * time.Minute) })
+	header, _ = credential.GenerateAuthorizationHeader(ctx, "POST", "/v3/pay/transactions/native", body)
+	if _, err = verifier.Verify(ctx, "POST", "/v3/pay/transactions/native", body, header); err == nil {
+		t.Errorf("Verify() expired timestamp should fail")
+	}
+
+	// 随机串记录使用校验方的时钟判断过期，注入的时钟早于系统时间时重放仍被拒绝
+	fixed := ClockFunc(func() time.Time { return time.Unix(1554208460, 0) })
+	verifier.Clock, credential.Clock = fixed, fixed
+	header, _ = credential.GenerateAuthorizationHeader(ctx, "POST", "/v3/pay/transactions/native", body)
+	if _, err = verifier.Verify(ctx, "POST", "/v3/pay/transactions/native", body, header); err != nil {
+		t.Fatalf("Verify() with fixed clock error = %v", err)
+	}
+	if _, err = verifier.Verify(ctx, "POST", "/v3/pay/transactions/native", body, header); !errors.Is(err, ErrNonceReplayed) {
+		t.Errorf("Verify() replay with fixed clock error = %v", err)
+	}
+}
@@ -20,7 +20,13 @@ import (
 // DownloadBill 下载账单
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_8.shtml
 func DownloadBill(ctx context.Context, hc *http.Client, credential Credential, bill model.Bill) ([]byte, error) {
-	body, err := Get(ctx, hc, credential, WithoutValidator, bill.DownloadURL)
+	return NewClient(hc, credential, WithoutValidator).DownloadBill(ctx, bill)
+}
+
+// DownloadBill 下载账单，账单文件的回包没有签名
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_8.shtml
+func (c *Client) DownloadBill(ctx context.Context, bill model.Bill) ([]byte, error) {
+	body, err := c.withValidator(WithoutValidator).Get(ctx, bill.DownloadURL)
 	if err != nil {
 		return nil, err
 	}
@@ -46,11 +52,17 @@ func DownloadBill(ctx context.Context, hc *http.Client, credential Credential, b
 	return body, err
 }
 
-const tradebillURL = `https://api.mch.weixin.qq.com/v3/bill/tradebill`
+const tradebillURL = `/v3/bill/tradebill`
 
 // TradeBill 申请交易账单
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_6.shtml
 func TradeBill(ctx context.Context, hc *http.Client, credential Credential, validator Validator, date time.Time, billType, tarType string) ([]byte, error) {
+	return NewClient(hc, credential, validator).TradeBill(ctx, date, billType, tarType)
+}
+
+// TradeBill 申请交易账单并下载
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_6.shtml
+func (c *Client) TradeBill(ctx context.Context, date time.Time, billType, tarType string) ([]byte, error) {
 	v := url.Values{}
 	v.Set("bill_date", date.Format("2006-01-02"))
 	if billType == "" {
@@ -60,7 +72,7 @@ func TradeBill(ctx context.Context, hc *http.Client, credential Credential, vali
 	if tarType != "" {
 		v.Set("tar_type", tarType)
 	}
-	body, err := Get(ctx, hc, credential, validator, tradebillURL+"?"+v.Encode())
+	body, err := c.Get(ctx, tradebillURL+"?"+v.Encode())
 	if err != nil {
 		return body, err
 	}
@@ -68,14 +80,20 @@ func TradeBill(ctx context.Context, hc *http.Client, credential Credential, vali
 	if err = json.Unmarshal(body, &bill); err != nil {
 		return body, err
 	}
-	return DownloadBill(ctx, hc, credential, bill)
+	return c.DownloadBill(ctx, bill)
 }
 
-const fundflowillURL = `https://api.mch.weixin.qq.com/v3/bill/fundflowbill`
+const fundflowillURL = `/v3/bill/fundflowbill`
 
 // FundflowBill 申请资金账单
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_7.shtml
 func FundflowBill(ctx context.Context, hc *http.Client, credential Credential, validator Validator, date time.Time, accountType, tarType string) ([]byte, error) {
+	return NewClient(hc, credential, validator).FundflowBill(ctx, date, accountType, tarType)
+}
+
+// FundflowBill 申请资金账单并下载
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_7.shtml
+func (c *Client) FundflowBill(ctx context.Context, date time.Time, accountType, tarType string) ([]byte, error) {
 	v := url.Values{}
 	v.Set("bill_date", date.Format("2006-01-02"))
 	if accountType != "" {
@@ -84,7 +102,7 @@ func FundflowBill(ctx context.Context, hc *http.Client, credential Credential, v
 	if tarType != "" {
 		v.Set("tar_type", tarType)
 	}
-	body, err := Get(ctx, hc, credential, validator, fundflowillURL+"?"+v.Encode())
+	body, err := c.Get(ctx, fundflowillURL+"?"+v.Encode())
 	if err != nil {
 		return body, err
 	}
@@ -92,5 +110,5 @@ func FundflowBill(ctx context.Context, hc *http.Client, credential Credential, v
 	if err = json.Unmarshal(body, &bill); err != nil {
 		return body, err
 	}
-	return DownloadBill(ctx, hc, credential, bill)
+	return c.DownloadBill(ctx, bill)
 }
@@ -9,12 +9,18 @@ import (
 	"github.com/perlyna/wechatpay/model"
 )
 
-const certificatesURL = `https://api.mch.weixin.qq.com/v3/certificates`
+const certificatesURL = `/v3/certificates`
 
 // GetCertificatesContext 获取平台证书列表
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3_partner/wechatpay/wechatpay5_1.shtml
 func GetCertificates(ctx context.Context, hc *http.Client, credential Credential) ([]model.CertificateInfo, error) {
-	body, err := Get(ctx, hc, credential, WithoutValidator, certificatesURL)
+	return NewClient(hc, credential, WithoutValidator).GetCertificates(ctx)
+}
+
+// GetCertificates 获取平台证书列表，平台证书下载前无法校验回包签名
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3_partner/wechatpay/wechatpay5_1.shtml
+func (c *Client) GetCertificates(ctx context.Context) ([]model.CertificateInfo, error) {
+	body, err := c.withValidator(WithoutValidator).Get(ctx, certificatesURL)
 	if err != nil {
 		return nil, err
 	}
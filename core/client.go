@@ -3,54 +3,165 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"strings"
+	"time"
+
+	"github.com/perlyna/wechatpay/util"
 )
 
+// Client 微信支付API客户端，封装了请求签名、敏感信息加密、回包校验等公共逻辑
+type Client struct {
+	HTTPClient  *http.Client // http client，为空时使用 http.DefaultClient
+	Credential  Credential   // 授权信息生成器
+	Validator   Validator    // 回包校验器
+	Encryptor   Encryptor    // 敏感信息加密器，为空时不加密请求中的敏感信息
+	Decryptor   Decryptor    // 敏感信息解密器，为空时回包中有敏感信息则返回 ErrNoDecryptor
+	Retry       *RetryPolicy // 重试策略，为空时不重试
+	Endpoint    *Endpoint    // 微信支付API域名，为空时使用 DefaultBaseURL 且不切换备用域名
+	Middlewares []Middleware // 请求中间件，先添加的在最外层
+	Logger      Logger       // 日志，为空时不记录
+	Metrics     Metrics      // 监控指标，为空时不记录
+	Tracer      Tracer       // 链路追踪，为空时不记录
+
+	DebugSignature bool // 签名诊断，开启后请求签名被拒绝或回包验签失败时返回包含签名串的 *SignatureError
+}
+
+// NewClient 创建微信支付API客户端
+func NewClient(hc *http.Client, credential Credential, validator Validator) *Client {
+	return &Client{HTTPClient: hc, Credential: credential, Validator: validator}
+}
+
+// withValidator 返回使用指定回包校验器的客户端副本
+func (c *Client) withValidator(validator Validator) *Client {
+	client := *c
+	client.Validator = validator
+	return &client
+}
+
+// Unmarshal 解析回包，并解密其中标记为 `wechatpay:"encrypt"` 的敏感信息
+func (c *Client) Unmarshal(ctx context.Context, body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return err
+	}
+	if c.Decryptor == nil {
+		if field := util.FindSensitiveField(v); field != "" {
+			return fmt.Errorf("%w: %s", ErrNoDecryptor, field)
+		}
+		return nil
+	}
+	return c.Decryptor.Decrypt(ctx, v)
+}
+
 // Get 向微信支付发送一个http get请求
-func Get(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string) ([]byte, error) {
-	return DoRequest(ctx, hc, credential, validator, http.MethodGet, requestURL, ApplicationJSON, "", "")
+func (c *Client) Get(ctx context.Context, requestURL string) ([]byte, error) {
+	return c.DoRequest(ctx, http.MethodGet, requestURL, ApplicationJSON, "", "")
 }
 
 // Post 向微信支付发送一个http post请求
-func Post(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
-	return do(ctx, hc, credential, validator, http.MethodPost, requestURL, requestBody)
+func (c *Client) Post(ctx context.Context, requestURL string, requestBody interface{}) ([]byte, error) {
+	return c.do(ctx, http.MethodPost, requestURL, requestBody)
 }
 
 // Patch 向微信支付发送一个http patch请求
-func Patch(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
-	return do(ctx, hc, credential, validator, http.MethodPatch, requestURL, requestBody)
+func (c *Client) Patch(ctx context.Context, requestURL string, requestBody interface{}) ([]byte, error) {
+	return c.do(ctx, http.MethodPatch, requestURL, requestBody)
 }
 
 // Put 向微信支付发送一个http put请求
-func Put(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
-	return do(ctx, hc, credential, validator, http.MethodPut, requestURL, requestBody)
+func (c *Client) Put(ctx context.Context, requestURL string, requestBody interface{}) ([]byte, error) {
+	return c.do(ctx, http.MethodPut, requestURL, requestBody)
 }
 
 // Delete 向微信支付发送一个http delete请求
-func Delete(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
-	return do(ctx, hc, credential, validator, http.MethodDelete, requestURL, requestBody)
+func (c *Client) Delete(ctx context.Context, requestURL string, requestBody interface{}) ([]byte, error) {
+	return c.do(ctx, http.MethodDelete, requestURL, requestBody)
 }
 
-func do(ctx context.Context, hc *http.Client, credential Credential, validator Validator, method, requestURL string, body interface{}) ([]byte, error) {
+func (c *Client) do(ctx context.Context, method, requestURL string, body interface{}) ([]byte, error) {
 	var reqBody string
 	if body != nil {
+		if c.Encryptor != nil {
+			encryptedBody, serialNo, err := c.Encryptor.Encrypt(ctx, body)
+			if err != nil {
+				return nil, fmt.Errorf("encrypt body err:%v", err)
+			}
+			if serialNo != "" {
+				body = encryptedBody
+				ctx = WithWechatPaySerial(ctx, serialNo)
+			}
+		}
 		bodyBytes, err := json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("json marshal body err:%v", err)
 		}
 		reqBody = string(bodyBytes)
 	}
-	return DoRequest(ctx, hc, credential, validator, method, requestURL, ApplicationJSON, reqBody, reqBody)
+	return c.DoRequest(ctx, method, requestURL, ApplicationJSON, reqBody, reqBody)
 }
 
-func DoRequest(ctx context.Context, hc *http.Client, credential Credential, validator Validator,
-	method, requestURL, contentType, reqBody, signBody string) ([]byte, error) {
-	var err error
+// DoRequest 向微信支付发送请求，生成授权信息并校验回包签名
+//
+// requestURL 可以是以 / 开头的路径，此时使用当前的微信支付API域名；
+// ctx 中通过 WithWechatPaySerial 设置了平台证书序列号时，请求头中会带上 Wechatpay-Serial；
+// 设置了重试策略时，可重试的错误会重新签名后重试
+func (c *Client) DoRequest(ctx context.Context, method, requestURL, contentType, reqBody, signBody string) (body []byte, err error) {
+	if c.Tracer != nil {
+		var span Span
+		ctx, span = StartSpan(ctx, c.Tracer, SpanRequest)
+		defer func() { EndSpan(span, err) }()
+		span.SetAttribute(AttrMethod, method)
+		if credentials, ok := c.Credential.(*WechatPayCredentials); ok {
+			span.SetAttribute(AttrMchID, credentials.MchID)
+		}
+	}
+	maxAttempts := c.Retry.maxAttempts(ctx, method)
+	for attempt := 1; ; attempt++ {
+		body, err = c.doAttempt(ctx, method, requestURL, contentType, reqBody, signBody)
+		if c.Tracer != nil {
+			SpanFromContext(ctx).SetAttribute(AttrAttempt, attempt)
+		}
+		if err == nil || attempt >= maxAttempts || !c.Retry.shouldRetry(ctx, err) {
+			return body, err
+		}
+		timer := time.NewTimer(c.Retry.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return body, err
+		case <-timer.C:
+		}
+	}
+}
+
+// doAttempt 发送一次请求，主域名连接失败时切换到备用域名再发送一次
+func (c *Client) doAttempt(ctx context.Context, method, requestURL, contentType, reqBody, signBody string) ([]byte, error) {
+	baseURL, primary := c.Endpoint.current()
+	body, err := c.send(ctx, method, c.Endpoint.resolve(requestURL, baseURL), contentType, reqBody, signBody)
+	if err == nil || !primary || !isConnectError(err) {
+		return body, err
+	}
+	backupURL, ok := c.Endpoint.failover()
+	if !ok {
+		return body, err
+	}
+	return c.send(ctx, method, c.Endpoint.resolve(requestURL, backupURL), contentType, reqBody, signBody)
+}
+
+// send 发送一次请求，每次请求都重新生成授权信息
+func (c *Client) send(ctx context.Context, method, requestURL, contentType, reqBody, signBody string) (body []byte, err error) {
+	ctx, cancel := c.Retry.attemptContext(ctx)
+	defer cancel()
+	if c.DebugSignature {
+		diagnostics := &SignatureDiagnostics{Method: method, URL: requestURL}
+		ctx = WithSignatureDiagnostics(ctx, diagnostics)
+		defer func() { err = c.diagnoseSignature(ctx, diagnostics, err) }()
+	}
 	var authorization string
 	request, err := http.NewRequestWithContext(ctx, method, requestURL,
 		strings.NewReader(reqBody))
@@ -60,44 +171,177 @@ func DoRequest(ctx context.Context, hc *http.Client, credential Credential, vali
 	request.Header.Set(Accept, "*/*")
 	request.Header.Set(ContentType, contentType)
 	request.Header.Set(UserAgent, UserAgentContent)
+	if serialNo := wechatPaySerialFromContext(ctx); serialNo != "" {
+		request.Header.Set(WechatPaySerial, serialNo)
+	}
 	// 生产授权信息
-	authorization, err = credential.GenerateAuthorizationHeader(ctx, method,
+	authorization, err = c.Credential.GenerateAuthorizationHeader(ctx, method,
 		request.URL.RequestURI(), signBody)
 	if err != nil {
 		return nil, fmt.Errorf("generate authorization err:%s", err.Error())
 	}
 	request.Header.Set(Authorization, authorization)
-	response, err := hc.Do(request)
-	if err != nil {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	exchange := &Exchange{Method: method, URL: requestURL, Body: signBody, Request: request}
+	defer func() {
+		c.logExchange(ctx, exchange, err)
+		c.observeExchange(ctx, exchange, err)
+		c.traceExchange(ctx, exchange)
+	}()
+	if err = chain(c.Middlewares, httpRoundTrip(hc))(ctx, exchange); err != nil {
+		return exchange.ResponseBody, err
+	}
+	if err = exchange.finish(); err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
-	body, err := CheckResponse(response)
+	response := exchange.Response
+	body, err = CheckResponse(response)
 	if err != nil {
 		return body, err
 	}
-	body, err = ioutil.ReadAll(response.Body)
-	if err != nil {
-		return body, fmt.Errorf("read response body err:[%s]", err.Error())
-	}
-	if err = validator.Validate(ctx, body, response.Header); err != nil {
+	body = exchange.ResponseBody
+	if err = c.Validator.Validate(ctx, body, response.Header); err != nil {
+		// 验签失败由 logExchange 记录，日志中包含回包的平台证书序列号
+		if c.Metrics != nil {
+			c.Metrics.SignatureFailure(ctx, SignatureSourceResponse)
+		}
 		return body, err
 	}
 	return body, nil
 }
 
+// diagnoseSignature 请求签名被拒绝或回包验签失败时，把错误包装为带有诊断信息的 *SignatureError
+func (c *Client) diagnoseSignature(ctx context.Context, diagnostics *SignatureDiagnostics, err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code != CodeSignError {
+			return err
+		}
+		diagnostics.RequestID = apiErr.RequestID
+	} else if diagnostics.VerifyMessage == "" {
+		// 回包未进入验签，与签名无关
+		return err
+	}
+	logTo(ctx, c.Logger, LogDebug, "wechatpay signature diagnostics", "method", diagnostics.Method,
+		"url", diagnostics.URL, "request_id", diagnostics.RequestID, "sign_message", diagnostics.SignMessage,
+		"serial_no", diagnostics.SerialNo, "verify_message", diagnostics.VerifyMessage,
+		"response_serial", diagnostics.ResponseSerial)
+	return &SignatureError{Diagnostics: diagnostics, Err: err}
+}
+
+// observeExchange 记录一次请求的监控指标
+func (c *Client) observeExchange(ctx context.Context, exchange *Exchange, err error) {
+	if c.Metrics == nil {
+		return
+	}
+	var statusCode int
+	if exchange.Response != nil {
+		statusCode = exchange.Response.StatusCode
+	}
+	var code string
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		code = apiErr.Code
+	}
+	c.Metrics.ObserveRequest(ctx, EndpointTemplate(ctx, exchange.Request.URL.Path), exchange.Method,
+		statusCode, code, exchange.Latency)
+}
+
+// traceExchange 在当前 span 上记录最近一次请求的地址模板、状态码及 Request-Id
+func (c *Client) traceExchange(ctx context.Context, exchange *Exchange) {
+	if c.Tracer == nil {
+		return
+	}
+	span := SpanFromContext(ctx)
+	span.SetAttribute(AttrEndpoint, EndpointTemplate(ctx, exchange.Request.URL.Path))
+	if exchange.Response != nil {
+		span.SetAttribute(AttrStatusCode, exchange.Response.StatusCode)
+	}
+	if exchange.RequestID != "" {
+		span.SetAttribute(AttrRequestID, exchange.RequestID)
+	}
+}
+
+// logExchange 记录一次请求的日志，请求及回包内容脱敏后以DEBUG级别记录
+func (c *Client) logExchange(ctx context.Context, exchange *Exchange, err error) {
+	if c.Logger == nil {
+		return
+	}
+	keyvals := []interface{}{"method", exchange.Method, "url", exchange.URL,
+		"request_id", exchange.RequestID, "latency", exchange.Latency}
+	if exchange.Response != nil {
+		keyvals = append(keyvals, "status", exchange.Response.StatusCode)
+	}
+	if err != nil {
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			keyvals = append(keyvals, "code", apiErr.Code)
+		} else if exchange.Response != nil {
+			keyvals = append(keyvals, "serial", exchange.Response.Header.Get(WechatPaySerial))
+		}
+		c.Logger.Log(ctx, LogError, "wechatpay api call failed", append(keyvals, "error", err)...)
+	} else {
+		c.Logger.Log(ctx, LogInfo, "wechatpay api call", keyvals...)
+	}
+	if !logEnabled(ctx, c.Logger, LogDebug) {
+		return
+	}
+	c.Logger.Log(ctx, LogDebug, "wechatpay api exchange", "method", exchange.Method, "url", exchange.URL,
+		"request_body", RedactJSON([]byte(exchange.Body)), "response_body", RedactJSON(exchange.ResponseBody))
+}
+
+// Get 向微信支付发送一个http get请求
+func Get(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string) ([]byte, error) {
+	return NewClient(hc, credential, validator).Get(ctx, requestURL)
+}
+
+// Post 向微信支付发送一个http post请求
+func Post(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
+	return NewClient(hc, credential, validator).Post(ctx, requestURL, requestBody)
+}
+
+// Patch 向微信支付发送一个http patch请求
+func Patch(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
+	return NewClient(hc, credential, validator).Patch(ctx, requestURL, requestBody)
+}
+
+// Put 向微信支付发送一个http put请求
+func Put(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
+	return NewClient(hc, credential, validator).Put(ctx, requestURL, requestBody)
+}
+
+// Delete 向微信支付发送一个http delete请求
+func Delete(ctx context.Context, hc *http.Client, credential Credential, validator Validator, requestURL string, requestBody interface{}) ([]byte, error) {
+	return NewClient(hc, credential, validator).Delete(ctx, requestURL, requestBody)
+}
+
+// DoRequest 向微信支付发送请求，生成授权信息并校验回包签名
+func DoRequest(ctx context.Context, hc *http.Client, credential Credential, validator Validator,
+	method, requestURL, contentType, reqBody, signBody string) ([]byte, error) {
+	return NewClient(hc, credential, validator).DoRequest(ctx, method, requestURL, contentType, reqBody, signBody)
+}
+
 // CheckResponse 校验回包是否有错误
 //
-// 当http回包的状态码的范围不是200-299之间的时候，会返回相应的错误信息，主要包括http状态码、回包错误码、回包错误信息提示
+// 当http回包的状态码的范围不是200-299之间的时候，会返回相应的错误信息，主要包括http状态码、回包错误码、回包错误信息提示、Request-Id
 func CheckResponse(res *http.Response) ([]byte, error) {
 	if res.StatusCode >= 200 && res.StatusCode <= 299 {
 		return nil, nil
 	}
+	requestID := strings.TrimSpace(res.Header.Get(RequestID))
 	slurp, err := ioutil.ReadAll(res.Body)
 	if err == nil {
 		jerr := &Error{StatusCode: res.StatusCode}
 		err = json.Unmarshal(slurp, jerr)
 		if err == nil {
+			jerr.Header = res.Header
+			jerr.RequestID = requestID
 			return slurp, jerr
 		}
 	}
@@ -105,6 +349,7 @@ func CheckResponse(res *http.Response) ([]byte, error) {
 		StatusCode: res.StatusCode,
 		Body:       string(slurp),
 		Header:     res.Header,
+		RequestID:  requestID,
 	}
 }
 
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRetry(t *testing.T) {
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get(Authorization))
+		if len(authorizations) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"code":"SYSTEM_ERROR","message":"系统错误"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := &Client{
+		HTTPClient: server.Client(),
+		Credential: &WechatPayCredentials{MchID: "1900009191",
+			Signer: &SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator: WithoutValidator,
+		Retry:     &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	ctx := context.Background()
+	if _, err := client.Post(ctx, server.URL+"/v3/refund/domestic/refunds", struct{}{}); !errors.Is(err, ErrSystemError) {
+		t.Fatalf("Post() error = %v, want %v", err, ErrSystemError)
+	}
+	if len(authorizations) != 1 {
+		t.Errorf("non-idempotent POST attempts = %d, want 1", len(authorizations))
+	}
+
+	authorizations = nil
+	if _, err := client.Post(WithIdempotent(ctx), server.URL+"/v3/refund/domestic/refunds", struct{}{}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if len(authorizations) != 3 {
+		t.Fatalf("idempotent POST attempts = %d, want 3", len(authorizations))
+	}
+	if authorizations[0] == authorizations[1] || authorizations[1] == authorizations[2] {
+		t.Errorf("every attempt should be signed with a fresh nonce")
+	}
+}
+
+func TestClientEndpointFailover(t *testing.T) {
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var paths []string
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer backup.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	primaryURL := closed.URL
+	closed.Close()
+
+	now := time.Now()
+	endpoint := NewEndpoint(primaryURL, backup.URL)
+	endpoint.SetClock(ClockFunc(func() time.Time { return now }))
+	client := &Client{
+		HTTPClient: backup.Client(),
+		Credential: &WechatPayCredentials{MchID: "1900009191",
+			Signer: &SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator: WithoutValidator,
+		Endpoint:  endpoint,
+	}
+	ctx := context.Background()
+	if _, err := client.Get(ctx, "/v3/certificates"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if endpoint.BaseURL() != backup.URL {
+		t.Errorf("BaseURL() = %s, want backup %s", endpoint.BaseURL(), backup.URL)
+	}
+	if _, err := client.Get(ctx, primaryURL+"/v3/certificates?algorithm_type=RSA"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(paths) != 2 || paths[1] != "/v3/certificates?algorithm_type=RSA" {
+		t.Errorf("backup paths = %v", paths)
+	}
+	// 冷却时间按注入的时钟计算
+	now = now.Add(DefaultCoolDown)
+	if endpoint.BaseURL() != primaryURL {
+		t.Errorf("BaseURL() after cool down = %s, want primary %s", endpoint.BaseURL(), primaryURL)
+	}
+}
+
+func TestClientMiddlewares(t *testing.T) {
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var order []string
+	var seen *Exchange
+	observe := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, exchange *Exchange) error {
+			order = append(order, "observe")
+			err := next(ctx, exchange)
+			seen = exchange
+			return err
+		}
+	}
+	stub := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, exchange *Exchange) error {
+			order = append(order, "stub")
+			if exchange.Request.Header.Get(Authorization) == "" {
+				t.Errorf("middleware should see signed request")
+			}
+			header := http.Header{}
+			header.Set(RequestID, "STUB-REQUEST-ID")
+			exchange.Response = &http.Response{StatusCode: http.StatusOK, Header: header}
+			exchange.ResponseBody = []byte(`{"trade_state":"SUCCESS"}`)
+			return nil
+		}
+	}
+	client := &Client{
+		Credential: &WechatPayCredentials{MchID: "1900009191",
+			Signer: &SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator:   WithoutValidator,
+		Middlewares: []Middleware{observe, stub},
+	}
+	tradeQuery, err := client.OrderQuery(context.Background(), "/v3/pay/transactions/id/4200000001?mchid=1900009191")
+	if err != nil {
+		t.Fatalf("OrderQuery() error = %v", err)
+	}
+	if tradeQuery.TradeState != "SUCCESS" {
+		t.Errorf("TradeState = %s, want SUCCESS", tradeQuery.TradeState)
+	}
+	if len(order) != 2 || order[0] != "observe" || order[1] != "stub" {
+		t.Errorf("middleware order = %v", order)
+	}
+	if seen.RequestID != "STUB-REQUEST-ID" || seen.Method != http.MethodGet || seen.URL != DefaultBaseURL+"/v3/pay/transactions/id/4200000001?mchid=1900009191" {
+		t.Errorf("exchange = %+v", seen)
+	}
+}
+
+func TestClientUnmarshalWithoutDecryptor(t *testing.T) {
+	type reply struct {
+		PayerPhone string `json:"payer_phone" wechatpay:"encrypt"`
+	}
+	client := &Client{}
+	var v reply
+	if err := client.Unmarshal(context.Background(), []byte(`{"payer_phone":"ciphertext"}`), &v); !errors.Is(err, ErrNoDecryptor) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrNoDecryptor)
+	}
+	if err := client.Unmarshal(context.Background(), []byte(`{}`), &reply{}); err != nil {
+		t.Errorf("Unmarshal() without sensitive field error = %v", err)
+	}
+}
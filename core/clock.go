@@ -0,0 +1,68 @@
+// 微信支付api v3 签名及验签使用的时钟和随机字符串
+package core
+
+import (
+	"crypto/rand"
+	"time"
+)
+
+// Clock 时钟，签名及校验时间戳时使用，测试时可以注入固定时间
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc 函数形式的时钟
+type ClockFunc func() time.Time
+
+// Now 当前时间
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock 系统时钟
+var SystemClock Clock = ClockFunc(time.Now)
+
+// NonceSource 随机字符串生成器，测试时可以注入固定的随机字符串
+type NonceSource interface {
+	Nonce() (string, error)
+}
+
+// NonceFunc 函数形式的随机字符串生成器
+type NonceFunc func() (string, error)
+
+// Nonce 生成随机字符串
+func (f NonceFunc) Nonce() (string, error) {
+	return f()
+}
+
+// RandomNonce 使用 crypto/rand 生成 NonceLength 位随机字符串，并发调用不会产生相同的随机字符串
+var RandomNonce NonceSource = NonceFunc(func() (string, error) {
+	return GenerateNonce(NonceLength)
+})
+
+// GenerateNonce 使用 crypto/rand 生成 length 位由 Symbols 组成的随机字符串
+func GenerateNonce(length int) (string, error) {
+	// 舍弃大于等于 maxByte 的字节，保证每个字符的概率相同
+	const maxByte = 256 - 256%len(Symbols)
+	nonce := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(nonce) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < maxByte && len(nonce) < length {
+				nonce = append(nonce, Symbols[int(b)%len(Symbols)])
+			}
+		}
+	}
+	return string(nonce), nil
+}
+
+// now 时钟为空时使用系统时钟
+func now(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClockAndNonce(t *testing.T) {
+	ctx := context.Background()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &SHA256WithRSASigner{MchCertificateSerialNo: "SERIAL", PrivateKey: privateKey}
+	fixed := time.Unix(1554208460, 0)
+	credential := &WechatPayCredentials{Signer: signer, MchID: "1900009191", Clock: ClockFunc(func() time.Time { return fixed }),
+		Nonce: NonceFunc(func() (string, error) { return "593BEC0C930BF1AFEB40B4A08C8FB242", nil })}
+	first, err := credential.GenerateAuthorizationHeader(ctx, "GET", "/v3/certificates", "")
+	if err != nil {
+		t.Fatalf("GenerateAuthorizationHeader() error = %v", err)
+	}
+	second, _ := credential.GenerateAuthorizationHeader(ctx, "GET", "/v3/certificates", "")
+	if first != second || !strings.Contains(first, `timestamp="1554208460"`) {
+		t.Errorf("GenerateAuthorizationHeader() = %s, %s, want same fixed authorization", first, second)
+	}
+
+	// 回包时间戳与注入的时钟比较，超过允许的偏差时校验失败
+	body := []byte(`{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`)
+	header := http.Header{}
+	header.Set(RequestID, "REQUEST")
+	header.Set(WechatPaySerial, "SERIAL")
+	header.Set(WechatPayTimestamp, strconv.FormatInt(fixed.Unix(), 10))
+	header.Set(WechatPayNonce, "NONCE")
+	message, _ := buildMessage(body, header)
+	result, _ := signer.Sign(ctx, message)
+	header.Set(WechatPaySignature, result.Signature)
+	validator := &WechatPayValidator{
+		Verifier: &WechatPayVerifier{Certificates: map[string]*x509.Certificate{"SERIAL": {PublicKey: &privateKey.PublicKey}}},
+		Clock:    ClockFunc(func() time.Time { return fixed.Add(6 * time.Minute) }),
+	}
+	if err = validator.Validate(ctx, body, header); err == nil {
+		t.Errorf("Validate() expired timestamp should fail")
+	}
+	validator.MaxSkew = 10 * time.Minute
+	if err = validator.Validate(ctx, body, header); err != nil {
+		t.Errorf("Validate() with skew error = %v", err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	nonces := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, err := RandomNonce.Nonce()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil || len(nonce) != NonceLength || nonces[nonce] {
+				t.Errorf("RandomNonce.Nonce() = %s, %v", nonce, err)
+			}
+			nonces[nonce] = true
+		}()
+	}
+	wg.Wait()
+}
@@ -11,9 +11,18 @@ const (
 	VideoMP4            = "video/mp4"                          // ContentType为video/mp4
 	UserAgent           = "User-Agent"                         // header中的UserAgent字段
 	UserAgentContent    = "WechatPay-Go-HttpClient/" + Version // UserAgent中的信息
-	HeaderAuthorization = "WECHATPAY2-SHA256-RSA2048 mchid=\"%s\",nonce_str=\"%s\",timestamp=\"%d\",serial_no=\"%s\"," +
+	HeaderAuthorization = SchemaSHA256RSA2048 + " mchid=\"%s\",nonce_str=\"%s\",timestamp=\"%d\",serial_no=\"%s\"," +
 		"signature=\"%s\"" //Authorization信息
+	HeaderAuthorizationFormat = "%s mchid=\"%s\",nonce_str=\"%s\",timestamp=\"%d\",serial_no=\"%s\"," +
+		"signature=\"%s\"" // 带认证类型的Authorization信息
+)
 
+// 签名算法及对应的认证类型
+const (
+	SHA256WithRSA       = "SHA256withRSA"             // RSA签名器名称
+	SM2WithSM3          = "SM2withSM3"                // 国密签名器名称
+	SchemaSHA256RSA2048 = "WECHATPAY2-SHA256-RSA2048" // RSA认证类型
+	SchemaSM2WithSM3    = "WECHATPAY2-SM2-WITH-SM3"   // 国密认证类型
 )
 
 // http response header 相关常量
@@ -4,8 +4,6 @@ package core
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"time"
 )
 
 // Credential Authorization信息生成器
@@ -16,8 +14,10 @@ type Credential interface {
 
 // WechatPayCredentials authorization生成器
 type WechatPayCredentials struct {
-	Signer Signer // 签名器
-	MchID  string // 商户号
+	Signer Signer      // 签名器
+	MchID  string      // 商户号
+	Clock  Clock       // 生成时间戳的时钟，为空时使用系统时钟
+	Nonce  NonceSource // 随机字符串生成器，为空时使用 RandomNonce
 }
 
 // GenerateAuthorizationHeader  生成http request header 中的authorization信息
@@ -26,31 +26,53 @@ func (c *WechatPayCredentials) GenerateAuthorizationHeader(ctx context.Context,
 	if c.Signer == nil {
 		return "", fmt.Errorf("you must init WechatPayCredentials with signer")
 	}
-	nonce := GenerateNonceStr(32)
+	nonceSource := c.Nonce
+	if nonceSource == nil {
+		nonceSource = RandomNonce
+	}
+	nonce, err := nonceSource.Nonce()
+	if err != nil {
+		return "", fmt.Errorf("generate nonce err:%w", err)
+	}
 
-	timestamp := time.Now().Unix()
+	timestamp := now(c.Clock).Unix()
 	message := fmt.Sprintf(FormatMessage, method, canonicalURL, timestamp, nonce, signBody)
 	signatureResult, err := c.Signer.Sign(ctx, message)
 	if err != nil {
 		return "", err
 	}
-	authorization = fmt.Sprintf(HeaderAuthorization, c.MchID, nonce, timestamp,
+	schema := AuthorizationSchema(c.Signer)
+	if signatureResult.Algorithm != "" {
+		schema = algorithmSchema(signatureResult.Algorithm)
+	}
+	authorization = fmt.Sprintf(HeaderAuthorizationFormat, schema, c.MchID, nonce, timestamp,
 		signatureResult.MchCertificateSerialNo, signatureResult.Signature)
+	if d := signatureDiagnosticsFromContext(ctx); d != nil {
+		d.SignMessage, d.SerialNo, d.Authorization = message, signatureResult.MchCertificateSerialNo, authorization
+	}
 	return authorization, nil
 }
 
-// nonceStr 随机字符串
-const nonceStr = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+// AuthorizationSchema 根据签名器的类型获取Authorization的认证类型
+func AuthorizationSchema(signer Signer) string {
+	return algorithmSchema(signer.GetName())
+}
 
-// nonceStrLength 随机字符串长度
-const nonceStrLength = len(nonceStr)
+// algorithmSchema 根据签名算法获取Authorization的认证类型
+func algorithmSchema(algorithm string) string {
+	if algorithm == SM2WithSM3 {
+		return SchemaSM2WithSM3
+	}
+	return SchemaSHA256RSA2048
+}
 
-// GenerateNonceStr 获取随机字符串
+// GenerateNonceStr 获取随机字符串，使用 crypto/rand 生成，生成失败时 panic
+//
+// Deprecated: 使用 GenerateNonce
 func GenerateNonceStr(length int) string {
-	bytes := make([]byte, length)
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	for i := 0; i < length; i++ {
-		bytes[i] = nonceStr[r.Intn(nonceStrLength)]
+	nonce, err := GenerateNonce(length)
+	if err != nil {
+		panic(err)
 	}
-	return string(bytes)
+	return nonce
 }
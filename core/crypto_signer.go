@@ -0,0 +1,145 @@
+// 微信支付api v3 基于crypto.Signer的签名器，用于商户私钥保存在KMS/HSM等密钥服务中的场景
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+// CryptoSigner 使用crypto.Signer进行签名的签名器
+//
+// 私钥不需要加载到内存中，只要密钥服务的客户端实现了crypto.Signer即可
+type CryptoSigner struct {
+	MchCertificateSerialNo string        // 商户证书序列号
+	Signer                 crypto.Signer // 商户私钥签名器
+}
+
+// GetName 获取签名器的名称，由签名器的公钥类型决定
+func (s *CryptoSigner) GetName() string {
+	if s.Signer != nil {
+		if _, ok := s.Signer.Public().(*sm2.PublicKey); ok {
+			return SM2WithSM3
+		}
+	}
+	return SHA256WithRSA
+}
+
+// 获取签名器的类型
+func (s *CryptoSigner) GetType() string {
+	return "CRYPTOSIGNER"
+}
+
+// 获取签名器的版本
+func (s *CryptoSigner) GetVersion() string {
+	return "1.0"
+}
+
+// Sign 通过crypto.Signer对信息进行签名
+//
+// RSA私钥对信息的SHA256摘要签名，SM2私钥对信息原文签名（SM3摘要由签名器计算）
+func (s *CryptoSigner) Sign(ctx context.Context, message string) (*SignatureResult, error) {
+	if s.Signer == nil {
+		return nil, fmt.Errorf("you must set crypto.Signer to use CryptoSigner")
+	}
+	if strings.TrimSpace(s.MchCertificateSerialNo) == "" {
+		return nil, fmt.Errorf("you must set mch certificate serial no to use CryptoSigner")
+	}
+	var signatureByte []byte
+	var err error
+	switch s.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		hashed := sha256.Sum256([]byte(message))
+		signatureByte, err = s.Signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	case *sm2.PublicKey:
+		signatureByte, err = s.Signer.Sign(rand.Reader, []byte(message), nil)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", s.Signer.Public())
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := &SignatureResult{MchCertificateSerialNo: s.MchCertificateSerialNo, Algorithm: s.GetName()}
+	ret.Signature = base64.StdEncoding.EncodeToString(signatureByte)
+	return ret, nil
+}
+
+// RemoteSignFunc 远程签名函数
+//
+// SHA256withRSA 传入的是信息的SHA256摘要，SM2withSM3 传入的是信息原文，返回签名的原始字节
+type RemoteSignFunc func(ctx context.Context, data []byte) (signature []byte, err error)
+
+// RemoteSigner 通过用户提供的函数调用远程密钥服务进行签名的签名器
+type RemoteSigner struct {
+	MchCertificateSerialNo string         // 商户证书序列号
+	Algorithm              string         // 签名算法 SHA256withRSA 或 SM2withSM3，默认 SHA256withRSA
+	SignFunc               RemoteSignFunc // 远程签名函数
+	Timeout                time.Duration  // 单次签名的超时时间，为0时仅受ctx控制
+}
+
+// GetName 获取签名器的名称
+func (s *RemoteSigner) GetName() string {
+	if s.Algorithm == SM2WithSM3 {
+		return SM2WithSM3
+	}
+	return SHA256WithRSA
+}
+
+// 获取签名器的类型
+func (s *RemoteSigner) GetType() string {
+	return "REMOTE"
+}
+
+// 获取签名器的版本
+func (s *RemoteSigner) GetVersion() string {
+	return "1.0"
+}
+
+// Sign 调用远程签名函数对信息进行签名，超时或ctx取消时立即返回
+func (s *RemoteSigner) Sign(ctx context.Context, message string) (*SignatureResult, error) {
+	if s.SignFunc == nil {
+		return nil, fmt.Errorf("you must set sign func to use RemoteSigner")
+	}
+	if strings.TrimSpace(s.MchCertificateSerialNo) == "" {
+		return nil, fmt.Errorf("you must set mch certificate serial no to use RemoteSigner")
+	}
+	data := []byte(message)
+	if s.GetName() == SHA256WithRSA {
+		hashed := sha256.Sum256(data)
+		data = hashed[:]
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		signature []byte
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		signature, err := s.SignFunc(ctx, data)
+		done <- result{signature: signature, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("remote sign err:%w", ctx.Err())
+	case r := <-done:
+		if r.err != nil {
+			return nil, fmt.Errorf("remote sign err:%w", r.err)
+		}
+		ret := &SignatureResult{MchCertificateSerialNo: s.MchCertificateSerialNo, Algorithm: s.GetName()}
+		ret.Signature = base64.StdEncoding.EncodeToString(r.signature)
+		return ret, nil
+	}
+}
@@ -0,0 +1,39 @@
+// 微信支付api v3 回包敏感信息解密器
+package core
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+
+	"github.com/perlyna/wechatpay/util"
+)
+
+// Decryptor 回包敏感信息解密器
+type Decryptor interface {
+	Decrypt(ctx context.Context, v interface{}) error // 解密回包结构体中的敏感信息，v 必须是指针
+}
+
+// ErrNoDecryptor 回包中有敏感信息字段，但客户端没有设置解密器，如使用 NewWithSigner 且商户私钥不可用
+var ErrNoDecryptor = errors.New("wechatpay: response has encrypted sensitive field but no decryptor")
+
+// WechatPayDecryptor 使用商户私钥解密回包中标记为 `wechatpay:"encrypt"` 的字段
+type WechatPayDecryptor struct {
+	PrivateKey   *rsa.PrivateKey   // 商户私钥
+	FallbackKeys []*rsa.PrivateKey // 商户证书轮换期间的其他商户私钥，PrivateKey 解密失败时依次尝试
+
+	// ErrorCollector 不为空时收集解密失败的字段并继续解密，失败的字段保留密文；为空时返回第一个解密错误
+	ErrorCollector func(ctx context.Context, err *util.SensitiveFieldError)
+}
+
+// Decrypt 解密回包结构体中的敏感信息
+func (decryptor *WechatPayDecryptor) Decrypt(ctx context.Context, v interface{}) error {
+	if decryptor.ErrorCollector == nil {
+		return util.DecryptSensitiveFields(v, decryptor.PrivateKey, decryptor.FallbackKeys...)
+	}
+	fieldErrs, err := util.DecryptSensitiveFieldsCollect(v, decryptor.PrivateKey, decryptor.FallbackKeys...)
+	for _, fieldErr := range fieldErrs {
+		decryptor.ErrorCollector(ctx, fieldErr)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+// 微信支付api v3 签名诊断
+package core
+
+import (
+	"context"
+	"fmt"
+)
+
+// SignatureDiagnostics 一次请求的签名诊断信息，用于排查 SIGN_ERROR 及回包验签失败
+//
+// 签名串中包含请求及回包内容，只应在排查问题时开启
+type SignatureDiagnostics struct {
+	Method            string `json:"method"`                       // 请求方法
+	URL               string `json:"url"`                          // 请求地址
+	SignMessage       string `json:"sign_message"`                 // 传给 Signer.Sign 的请求签名串
+	SerialNo          string `json:"serial_no"`                    // 请求签名使用的商户证书序列号
+	Authorization     string `json:"authorization"`                // 请求的 Authorization
+	RequestID         string `json:"request_id,omitempty"`         // 微信支付回包请求ID
+	VerifyMessage     string `json:"verify_message,omitempty"`     // buildMessage 构造的回包验签串
+	ResponseSerial    string `json:"response_serial,omitempty"`    // 回包的平台证书序列号
+	ResponseSignature string `json:"response_signature,omitempty"` // 回包的签名
+}
+
+// SignatureError 开启签名诊断时，请求签名被拒绝或回包验签失败返回的错误
+//
+// errors.Is(err, ErrSignError) 等判断仍然有效，可以用 errors.As 取出诊断信息
+type SignatureError struct {
+	Diagnostics *SignatureDiagnostics // 签名诊断信息
+	Err         error                 // 原始错误
+}
+
+// Error 错误信息，包含签名串及证书序列号
+func (e *SignatureError) Error() string {
+	d := e.Diagnostics
+	return fmt.Sprintf("%v, sign_message=%q serial_no=%s verify_message=%q response_serial=%s",
+		e.Err, d.SignMessage, d.SerialNo, d.VerifyMessage, d.ResponseSerial)
+}
+
+// Unwrap 原始错误
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+type signatureDiagnosticsKey struct{}
+
+// WithSignatureDiagnostics 在 ctx 中记录签名诊断信息，WechatPayCredentials 及 WechatPayValidator 会把签名串等写入 d
+func WithSignatureDiagnostics(ctx context.Context, d *SignatureDiagnostics) context.Context {
+	return context.WithValue(ctx, signatureDiagnosticsKey{}, d)
+}
+
+// signatureDiagnosticsFromContext 获取 ctx 中的签名诊断信息，未开启时为空
+func signatureDiagnosticsFromContext(ctx context.Context) *SignatureDiagnostics {
+	d, _ := ctx.Value(signatureDiagnosticsKey{}).(*SignatureDiagnostics)
+	return d
+}
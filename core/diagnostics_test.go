@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientSignatureDiagnostics(t *testing.T) {
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	platformKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	status, signed, code := http.StatusUnauthorized, false, CodeSignError
+	stub := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, exchange *Exchange) error {
+			header := http.Header{}
+			header.Set(RequestID, "STUB-REQUEST-ID")
+			exchange.ResponseBody = []byte(`{"code":"` + code + `","message":"错误"}`)
+			if signed {
+				exchange.ResponseBody = []byte(`{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`)
+				header.Set(WechatPaySerial, "PLATFORM")
+				header.Set(WechatPayTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+				header.Set(WechatPayNonce, "NONCE")
+				header.Set(WechatPaySignature, "aW52YWxpZA==")
+			}
+			exchange.Response = &http.Response{StatusCode: status, Header: header}
+			return nil
+		}
+	}
+	client := &Client{
+		Credential: &WechatPayCredentials{MchID: "1900009191",
+			Signer: &SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator: &WechatPayValidator{Verifier: &WechatPayVerifier{
+			Certificates: map[string]*x509.Certificate{"PLATFORM": {PublicKey: &platformKey.PublicKey}}}},
+		Middlewares:    []Middleware{stub},
+		DebugSignature: true,
+	}
+	ctx := context.Background()
+	_, err := client.Post(ctx, "/v3/pay/transactions/native", map[string]string{"mchid": "1900009191"})
+	var signatureErr *SignatureError
+	if !errors.As(err, &signatureErr) || !errors.Is(err, ErrSignError) {
+		t.Fatalf("Post() error = %v, want *SignatureError", err)
+	}
+	d := signatureErr.Diagnostics
+	if !strings.HasPrefix(d.SignMessage, "POST\n/v3/pay/transactions/native\n") || d.SerialNo != "MCH" ||
+		!strings.Contains(d.Authorization, `serial_no="MCH"`) || d.RequestID != "STUB-REQUEST-ID" {
+		t.Errorf("diagnostics = %+v", d)
+	}
+
+	status, signed = http.StatusOK, true
+	_, err = client.Post(ctx, "/v3/pay/transactions/native", map[string]string{"mchid": "1900009191"})
+	if !errors.As(err, &signatureErr) || !strings.HasSuffix(signatureErr.Diagnostics.VerifyMessage,
+		"\nNONCE\n{\"code_url\":\"weixin://wxpay/bizpayurl?pr=p4lpSuKzz\"}\n") {
+		t.Fatalf("Post() validate error = %v", err)
+	}
+
+	// 与签名无关的错误不包装
+	status, signed, code = http.StatusNotFound, false, CodeOrderNotExist
+	if _, err = client.Get(ctx, "/v3/pay/transactions/id/1"); errors.As(err, &signatureErr) {
+		t.Errorf("Get() error = %v, want plain error", err)
+	}
+}
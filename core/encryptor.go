@@ -0,0 +1,66 @@
+// 微信支付api v3 请求敏感信息加密器
+package core
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/perlyna/wechatpay/util"
+)
+
+// Encryptor 请求敏感信息加密器
+type Encryptor interface {
+	// Encrypt 加密请求中的敏感信息，返回加密后的请求及使用的平台证书序列号；没有敏感信息时序列号为空
+	Encrypt(ctx context.Context, body interface{}) (encryptedBody interface{}, serialNo string, err error)
+}
+
+// WechatPayEncryptor 使用最新的有效平台证书加密请求中标记为 `wechatpay:"encrypt"` 的字段
+type WechatPayEncryptor struct {
+	Certificates map[string]*x509.Certificate // key 微信支付平台证书序列号 value 微信支付平台证书
+	Clock        Clock                        // 判断平台证书是否有效的时钟，为空时使用系统时钟
+}
+
+// Encrypt 加密请求中的敏感信息
+func (encryptor *WechatPayEncryptor) Encrypt(ctx context.Context, body interface{}) (interface{}, string, error) {
+	serialNo, certificate := encryptor.newestCertificate(now(encryptor.Clock))
+	encryptedBody, ok, err := util.EncryptSensitiveFields(body, certificate)
+	if err != nil {
+		if certificate == nil {
+			return nil, "", fmt.Errorf("there is no valid certificate in wechat pay encryptor")
+		}
+		return nil, "", err
+	}
+	if !ok {
+		return body, "", nil
+	}
+	return encryptedBody, serialNo, nil
+}
+
+// newestCertificate 返回在 now 时刻有效、启用时间最晚的平台证书
+func (encryptor *WechatPayEncryptor) newestCertificate(now time.Time) (string, *x509.Certificate) {
+	var serialNo string
+	var newest *x509.Certificate
+	for serial, certificate := range encryptor.Certificates {
+		if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+			continue
+		}
+		if newest == nil || certificate.NotBefore.After(newest.NotBefore) {
+			serialNo, newest = serial, certificate
+		}
+	}
+	return serialNo, newest
+}
+
+type wechatPaySerialKey struct{}
+
+// WithWechatPaySerial 设置请求头 Wechatpay-Serial 的平台证书序列号，请求中包含加密的敏感信息时使用
+func WithWechatPaySerial(ctx context.Context, serialNo string) context.Context {
+	return context.WithValue(ctx, wechatPaySerialKey{}, serialNo)
+}
+
+func wechatPaySerialFromContext(ctx context.Context) string {
+	serialNo, _ := ctx.Value(wechatPaySerialKey{}).(string)
+	return serialNo
+}
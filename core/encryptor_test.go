@@ -0,0 +1,73 @@
+package core_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/util"
+)
+
+type testSensitiveReq struct {
+	OutBatchNo string `json:"out_batch_no"`
+	Detail     []struct {
+		UserName string `json:"user_name" wechatpay:"encrypt"`
+	} `json:"transfer_detail_list"`
+}
+
+func TestClientEncryptSensitiveFields(t *testing.T) {
+	now := time.Now()
+	_, oldCertificate := fixtures.NewCertificateAt("Tenpay.com Root CA", now.Add(-2*time.Hour))
+	newKey, newCertificate := fixtures.NewCertificateAt("Tenpay.com Root CA", now.Add(-time.Hour))
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var gotSerial string
+	var gotBody testSensitiveReq
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSerial = r.Header.Get(core.WechatPaySerial)
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &gotBody)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := &core.Client{
+		HTTPClient: server.Client(),
+		Credential: &core.WechatPayCredentials{MchID: "1900009191",
+			Signer: &core.SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator: core.WithoutValidator,
+		Encryptor: &core.WechatPayEncryptor{Certificates: map[string]*x509.Certificate{
+			"OLD": oldCertificate,
+			"NEW": newCertificate,
+		}},
+	}
+	req := testSensitiveReq{OutBatchNo: "plfk2020042013"}
+	req.Detail = append(req.Detail, struct {
+		UserName string `json:"user_name" wechatpay:"encrypt"`
+	}{UserName: "张三"})
+	if _, err := client.Post(context.Background(), server.URL+"/v3/transfer/batches", req); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if gotSerial != "NEW" {
+		t.Errorf("Wechatpay-Serial = %s, want NEW", gotSerial)
+	}
+	if req.Detail[0].UserName != "张三" {
+		t.Errorf("request body should not be modified, got %s", req.Detail[0].UserName)
+	}
+	plaintext, err := util.DecryptOAEP(gotBody.Detail[0].UserName, newKey)
+	if err != nil || plaintext != "张三" {
+		t.Errorf("DecryptOAEP() = %s, %v, want 张三", plaintext, err)
+	}
+	if gotBody.OutBatchNo != req.OutBatchNo {
+		t.Errorf("out_batch_no = %s, want %s", gotBody.OutBatchNo, req.OutBatchNo)
+	}
+}
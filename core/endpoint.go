@@ -0,0 +1,106 @@
+// 微信支付api v3 域名及备用域名切换
+package core
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 微信支付API域名
+const (
+	DefaultBaseURL  = "https://api.mch.weixin.qq.com"  // 主域名
+	BackupBaseURL   = "https://api2.mch.weixin.qq.com" // 备用域名，主域名不可用时使用
+	DefaultCoolDown = time.Minute                      // 切换到备用域名后，再次尝试主域名前的默认冷却时间
+)
+
+// Endpoint 微信支付API域名
+//
+// 请求主域名出现连接错误时自动切换到备用域名重试，冷却时间过后再次尝试主域名
+type Endpoint struct {
+	Primary  string        // 主域名，如 https://api.mch.weixin.qq.com
+	Backup   string        // 备用域名，为空时不切换
+	CoolDown time.Duration // 切换到备用域名后，再次尝试主域名前的冷却时间，为0时使用 DefaultCoolDown
+
+	mu            sync.Mutex
+	failoverUntil time.Time // 在此之前使用备用域名
+	clock         Clock     // 判断冷却时间的时钟，为空时使用系统时钟
+}
+
+// NewEndpoint 创建微信支付API域名，backup 为空时不切换备用域名
+func NewEndpoint(primary, backup string) *Endpoint {
+	return &Endpoint{Primary: strings.TrimRight(primary, "/"), Backup: strings.TrimRight(backup, "/")}
+}
+
+// DefaultEndpoint 微信支付默认域名，主域名不可用时切换到 api2.mch.weixin.qq.com
+func DefaultEndpoint() *Endpoint {
+	return NewEndpoint(DefaultBaseURL, BackupBaseURL)
+}
+
+// SetClock 设置判断冷却时间的时钟，测试时可以注入固定时间验证域名切换
+func (e *Endpoint) SetClock(clock Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock = clock
+}
+
+// BaseURL 当前使用的域名
+func (e *Endpoint) BaseURL() string {
+	baseURL, _ := e.current()
+	return baseURL
+}
+
+// current 当前使用的域名，以及是否为主域名
+func (e *Endpoint) current() (string, bool) {
+	if e == nil {
+		return DefaultBaseURL, true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Backup != "" && now(e.clock).Before(e.failoverUntil) {
+		return e.Backup, false
+	}
+	return e.Primary, true
+}
+
+// failover 主域名连接失败，在冷却时间内切换到备用域名；没有备用域名时返回false
+func (e *Endpoint) failover() (string, bool) {
+	if e == nil || e.Backup == "" {
+		return "", false
+	}
+	coolDown := e.CoolDown
+	if coolDown <= 0 {
+		coolDown = DefaultCoolDown
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failoverUntil = now(e.clock).Add(coolDown)
+	return e.Backup, true
+}
+
+// resolve 生成请求的完整地址：以 / 开头的路径拼接 baseURL，以主域名开头的地址替换为 baseURL
+func (e *Endpoint) resolve(requestURL, baseURL string) string {
+	if strings.HasPrefix(requestURL, "/") {
+		return baseURL + requestURL
+	}
+	primary := DefaultBaseURL
+	if e != nil {
+		primary = e.Primary
+	}
+	if baseURL != primary && strings.HasPrefix(requestURL, primary+"/") {
+		return baseURL + strings.TrimPrefix(requestURL, primary)
+	}
+	return requestURL
+}
+
+// isConnectError 是否为建立连接时的错误（域名解析失败、连接被拒绝等），此时请求没有发出，可以安全地切换域名重试
+func isConnectError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -3,8 +3,11 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 )
 
@@ -16,6 +19,81 @@ type Error struct {
 	Details    []interface{} `json:"details,omitempty"` // 将回包中body解析出的detail信息，仅回包不符合预期时存在
 	Body       string        `json:"body,omitempty"`    // http回包中的body信息
 	Header     http.Header   `json:"header,omitempty"`  // http回包中的header信息
+	RequestID  string        `json:"-"`                 // http回包header中的Request-Id，排查问题时提供给微信支付
+}
+
+// 微信支付文档中的公共错误码
+const (
+	CodeSystemError       = "SYSTEM_ERROR"        // 系统错误，请使用相同参数稍后重新调用
+	CodeFrequencyLimited  = "FREQUENCY_LIMITED"   // 频率超限，请降低请求接口频率
+	CodeOrderNotExist     = "ORDER_NOT_EXIST"     // 订单不存在
+	CodeResourceNotExists = "RESOURCE_NOT_EXISTS" // 资源不存在
+	CodeNotEnough         = "NOT_ENOUGH"          // 余额不足
+	CodeSignError         = "SIGN_ERROR"          // 签名错误
+	CodeInvalidRequest    = "INVALID_REQUEST"     // 无效请求，请根据接口返回的详细信息检查
+	CodeParamError        = "PARAM_ERROR"         // 参数错误
+	CodeBankError         = "BANK_ERROR"          // 银行系统异常，请使用相同参数稍后重新调用
+)
+
+// 错误码对应的哨兵错误，可以使用 errors.Is(err, core.ErrSystemError) 判断
+var (
+	ErrSystemError       = &Error{Code: CodeSystemError}
+	ErrFrequencyLimited  = &Error{Code: CodeFrequencyLimited}
+	ErrOrderNotExist     = &Error{Code: CodeOrderNotExist}
+	ErrResourceNotExists = &Error{Code: CodeResourceNotExists}
+	ErrNotEnough         = &Error{Code: CodeNotEnough}
+	ErrSignError         = &Error{Code: CodeSignError}
+	ErrInvalidRequest    = &Error{Code: CodeInvalidRequest}
+	ErrParamError        = &Error{Code: CodeParamError}
+	ErrBankError         = &Error{Code: CodeBankError}
+)
+
+// Is 按错误码判断是否为同一类错误，ORDER_NOT_EXIST 与 RESOURCE_NOT_EXISTS 视为同一类
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Code == "" {
+		return false
+	}
+	return normalizeCode(e.Code) == normalizeCode(t.Code)
+}
+
+func normalizeCode(code string) string {
+	if code == CodeOrderNotExist {
+		return CodeResourceNotExists
+	}
+	return code
+}
+
+// Temporary 是否为微信支付侧的暂时性错误：5xx、SYSTEM_ERROR、BANK_ERROR
+func (e *Error) Temporary() bool {
+	if e.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	return e.Code == CodeSystemError || e.Code == CodeBankError
+}
+
+// Retryable 使用相同参数稍后重试是否可能成功：暂时性错误，以及 429、FREQUENCY_LIMITED 等频率限制
+func (e *Error) Retryable() bool {
+	if e.Temporary() {
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.Code == CodeFrequencyLimited
+}
+
+// IsRetryable 判断错误是否可以重试：连接失败等网络错误，以及 Error.Retryable；ctx 取消或超时不可重试
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
 }
 
 //Error 返回自定义错误类型的字符串内容
@@ -25,6 +103,9 @@ func (e *Error) Error() string {
 	if e.Message != "" {
 		_, _ = fmt.Fprintf(&buf, "Message: %s", e.Message)
 	}
+	if e.RequestID != "" {
+		_, _ = fmt.Fprintf(&buf, " RequestID: %s", e.RequestID)
+	}
 	if len(e.Details) > 0 {
 		var detailBuf bytes.Buffer
 		enc := json.NewEncoder(&detailBuf)
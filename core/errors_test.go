@@ -0,0 +1,54 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckResponseError(t *testing.T) {
+	newResponse := func(statusCode int, body string) *http.Response {
+		header := http.Header{}
+		header.Set(RequestID, "08F78BB5AF0610D4B2E7C4EB02")
+		return &http.Response{StatusCode: statusCode, Header: header, Body: ioutil.NopCloser(strings.NewReader(body))}
+	}
+
+	_, err := CheckResponse(newResponse(http.StatusNotFound, `{"code":"ORDER_NOT_EXIST","message":"订单不存在"}`))
+	wrapped := fmt.Errorf("query order: %w", err)
+	if !errors.Is(wrapped, ErrOrderNotExist) || !errors.Is(wrapped, ErrResourceNotExists) {
+		t.Errorf("errors.Is(%v, ErrOrderNotExist) = false, want true", err)
+	}
+	if errors.Is(wrapped, ErrSystemError) {
+		t.Errorf("errors.Is(%v, ErrSystemError) = true, want false", err)
+	}
+	var apiErr *Error
+	if !errors.As(wrapped, &apiErr) || apiErr.RequestID != "08F78BB5AF0610D4B2E7C4EB02" {
+		t.Errorf("RequestID = %v, want 08F78BB5AF0610D4B2E7C4EB02", apiErr)
+	}
+	if IsRetryable(wrapped) {
+		t.Errorf("IsRetryable(%v) = true, want false", err)
+	}
+
+	tests := []struct {
+		statusCode int
+		body       string
+		temporary  bool
+		retryable  bool
+	}{
+		{http.StatusInternalServerError, `{"code":"SYSTEM_ERROR","message":"系统错误"}`, true, true},
+		{http.StatusTooManyRequests, `{"code":"FREQUENCY_LIMITED","message":"频率超限"}`, false, true},
+		{http.StatusForbidden, `{"code":"NOT_ENOUGH","message":"余额不足"}`, false, false},
+		{http.StatusBadGateway, `<html>bad gateway</html>`, true, true},
+	}
+	for _, tt := range tests {
+		_, err := CheckResponse(newResponse(tt.statusCode, tt.body))
+		apiErr := err.(*Error)
+		if apiErr.Temporary() != tt.temporary || apiErr.Retryable() != tt.retryable {
+			t.Errorf("%d %s Temporary() = %v Retryable() = %v, want %v %v", tt.statusCode, tt.body,
+				apiErr.Temporary(), apiErr.Retryable(), tt.temporary, tt.retryable)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// 微信支付api v3 结构化日志
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel 日志级别
+type LogLevel int
+
+// 日志级别
+const (
+	LogDebug LogLevel = iota // 调试信息，包含脱敏后的请求及回包内容
+	LogInfo                  // 请求成功、证书更新、通知处理等
+	LogWarn                  // 签名校验失败等
+	LogError                 // 请求失败等
+)
+
+// String 日志级别名称
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Logger 结构化日志接口，keyvals 为成对的键值，如 "request_id", "08F78BB5AF0610D4B2E7C4EB02"
+//
+// SDK 记录的日志已经脱敏，不会包含 Authorization、APIv3密钥、商户私钥，以及手机号、openid、加密字段等个人信息
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{})
+}
+
+// LevelEnabler 可以判断日志级别是否输出的 Logger，SDK 在构造脱敏的请求及回包内容等耗时的日志前检查
+type LevelEnabler interface {
+	Enabled(ctx context.Context, level LogLevel) bool
+}
+
+// StdLogger 使用标准库 log.Logger 输出日志
+type StdLogger struct {
+	Logger *log.Logger // 为空时使用 log 包默认的 Logger
+	Level  LogLevel    // 最低输出级别
+}
+
+// Enabled 是否输出 level 级别的日志
+func (l *StdLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	return level >= l.Level
+}
+
+// Log 输出一条日志，格式为 LEVEL msg key=value ...
+func (l *StdLogger) Log(ctx context.Context, level LogLevel, msg string, keyvals ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "%s %s", level, msg)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		_, _ = fmt.Fprintf(&buf, " %v=%q", keyvals[i], fmt.Sprint(keyvals[i+1]))
+	}
+	if l.Logger == nil {
+		log.Print(buf.String())
+		return
+	}
+	l.Logger.Print(buf.String())
+}
+
+// logEnabled logger 是否输出 level 级别的日志，未实现 LevelEnabler 时总是输出
+func logEnabled(ctx context.Context, logger Logger, level LogLevel) bool {
+	if enabler, ok := logger.(LevelEnabler); ok {
+		return enabler.Enabled(ctx, level)
+	}
+	return logger != nil
+}
+
+// logTo 记录日志，logger 为空时不记录
+func logTo(ctx context.Context, logger Logger, level LogLevel, msg string, keyvals ...interface{}) {
+	if logger != nil {
+		logger.Log(ctx, level, msg, keyvals...)
+	}
+}
+
+// RedactKeys 日志中需要脱敏的JSON字段，包括个人信息及加密字段
+var RedactKeys = map[string]bool{
+	"payer_phone":     true,
+	"openid":          true,
+	"payer_openid":    true,
+	"sub_openid":      true,
+	"sp_openid":       true,
+	"user_name":       true,
+	"id_card_number":  true,
+	"account_name":    true,
+	"account_number":  true,
+	"mobile":          true,
+	"phone":           true,
+	"contact_phone":   true,
+	"email":           true,
+	"ciphertext":      true,
+	"associated_data": true,
+	"nonce":           true,
+}
+
+// RedactJSON 对JSON内容中的敏感字段脱敏，非JSON内容只输出长度
+func RedactJSON(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	redacted, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	return string(redacted)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if RedactKeys[strings.ToLower(key)] {
+				value[key] = "***"
+				continue
+			}
+			value[key] = redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactJSON(t *testing.T) {
+	body := []byte(`{"complaint_id":"200201820200101080076610000","payer_phone":"Ln1ZFVH4q7e4Q...",
+		"payer":{"openid":"oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},"resource":{"ciphertext":"5kH1","nonce":"fdasflkja484w"},
+		"amount":{"total":100}}`)
+	redacted := RedactJSON(body)
+	for _, secret := range []string{"Ln1ZFVH4q7e4Q", "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o", "5kH1", "fdasflkja484w"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("RedactJSON() = %s, should not contain %s", redacted, secret)
+		}
+	}
+	if !strings.Contains(redacted, "200201820200101080076610000") || !strings.Contains(redacted, `"total":100`) {
+		t.Errorf("RedactJSON() = %s, should keep non sensitive fields", redacted)
+	}
+	if got := RedactJSON([]byte("交易时间,公众账号ID")); got != "<27 bytes>" {
+		t.Errorf("RedactJSON() non json = %s", got)
+	}
+}
+
+func TestClientLogsSignatureFailureOnce(t *testing.T) {
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	platformKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	stub := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, exchange *Exchange) error {
+			header := http.Header{}
+			header.Set(WechatPaySerial, "PLATFORM")
+			header.Set(WechatPayTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+			header.Set(WechatPayNonce, "NONCE")
+			header.Set(WechatPaySignature, "aW52YWxpZA==")
+			exchange.ResponseBody = []byte(`{"code_url":"weixin://wxpay/bizpayurl?pr=p4lpSuKzz"}`)
+			exchange.Response = &http.Response{StatusCode: http.StatusOK, Header: header}
+			return nil
+		}
+	}
+	var buf bytes.Buffer
+	logger := &StdLogger{Logger: log.New(&buf, "", 0), Level: LogInfo}
+	client := &Client{
+		Credential: &WechatPayCredentials{MchID: "1900009191",
+			Signer: &SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator: &WechatPayValidator{Verifier: &WechatPayVerifier{
+			Certificates: map[string]*x509.Certificate{"PLATFORM": {PublicKey: &platformKey.PublicKey}}}},
+		Middlewares: []Middleware{stub},
+		Logger:      logger,
+	}
+	if _, err := client.Get(context.Background(), "/v3/certificates"); err == nil {
+		t.Fatal("Get() with invalid signature should fail")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "ERROR wechatpay api call failed") || !strings.Contains(lines[0], `serial="PLATFORM"`) {
+		t.Errorf("signature failure logs = %q, want one ERROR line with serial", lines)
+	}
+
+	buf.Reset()
+	logger.Level = LogDebug
+	_, _ = client.Get(context.Background(), "/v3/certificates")
+	if !strings.Contains(buf.String(), "DEBUG wechatpay api exchange") {
+		t.Errorf("debug level should log the redacted exchange: %s", buf.String())
+	}
+}
@@ -0,0 +1,163 @@
+// 微信支付api v3 监控指标
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 通知处理结果
+const (
+	NotifySuccess   = "success"   // 处理成功
+	NotifyDuplicate = "duplicate" // 重复通知
+	NotifyFailure   = "failure"   // 验签、解密或业务处理失败
+	NotifyUnhandled = "unhandled" // 没有对应的处理器，按成功应答
+)
+
+// 签名校验失败的来源
+const (
+	SignatureSourceResponse     = "response"     // API回包
+	SignatureSourceNotification = "notification" // 回调通知
+)
+
+// Metrics 监控指标接口
+type Metrics interface {
+	// ObserveRequest 记录一次API请求，endpoint 为去掉ID的地址模板，code 为微信支付错误码，网络错误时 statusCode 为0
+	ObserveRequest(ctx context.Context, endpoint, method string, statusCode int, code string, latency time.Duration)
+	// SignatureFailure 记录一次签名校验失败
+	SignatureFailure(ctx context.Context, source string)
+	// CertificateExpiry 记录平台证书的过期时间，用于证书过期倒计时
+	CertificateExpiry(serialNo string, expireTime time.Time)
+	// NotificationProcessed 记录一次回调通知的处理结果
+	NotificationProcessed(ctx context.Context, eventType, outcome string)
+}
+
+type endpointKey struct{}
+
+// WithEndpoint 设置请求的地址模板，如 /v3/pay/transactions/id/{transaction_id}，用于监控指标及链路追踪
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+// EndpointTemplate 获取请求的地址模板：优先使用 WithEndpoint 设置的模板，否则把路径中包含6位以上数字的部分替换为 {id}
+func EndpointTemplate(ctx context.Context, path string) string {
+	if endpoint, ok := ctx.Value(endpointKey{}).(string); ok && endpoint != "" {
+		return endpoint
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		digits := 0
+		for _, r := range segment {
+			if unicode.IsDigit(r) {
+				digits++
+			}
+		}
+		if digits >= 6 {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// latencyBuckets 请求耗时直方图的分桶上限（毫秒）
+var latencyBuckets = []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// ExpvarMetrics 使用标准库 expvar 发布的监控指标，可通过 /debug/vars 查看
+type ExpvarMetrics struct {
+	requests          *expvar.Map // endpoint -> 请求次数
+	errors            *expvar.Map // "endpoint code" -> 错误次数
+	latency           *expvar.Map // endpoint -> 耗时直方图 le_<ms> -> 次数
+	signatureFailures *expvar.Map // source -> 签名校验失败次数
+	notifications     *expvar.Map // "event_type outcome" -> 通知处理次数
+
+	mu          sync.Mutex
+	expireTimes map[string]time.Time // 平台证书序列号 -> 过期时间
+}
+
+// expvarPublishMu 保证检查 name 是否已发布与发布之间不被打断
+var expvarPublishMu sync.Mutex
+
+// NewExpvarMetrics 创建并以 name 发布监控指标，name 已被发布时返回错误
+func NewExpvarMetrics(name string) (*ExpvarMetrics, error) {
+	expvarPublishMu.Lock()
+	defer expvarPublishMu.Unlock()
+	if expvar.Get(name) != nil {
+		return nil, fmt.Errorf("expvar %s is already published", name)
+	}
+	m := &ExpvarMetrics{
+		requests:          new(expvar.Map).Init(),
+		errors:            new(expvar.Map).Init(),
+		latency:           new(expvar.Map).Init(),
+		signatureFailures: new(expvar.Map).Init(),
+		notifications:     new(expvar.Map).Init(),
+		expireTimes:       make(map[string]time.Time),
+	}
+	root := expvar.NewMap(name)
+	root.Set("requests", m.requests)
+	root.Set("errors", m.errors)
+	root.Set("latency_ms", m.latency)
+	root.Set("signature_failures", m.signatureFailures)
+	root.Set("notifications", m.notifications)
+	root.Set("certificate_expiry_seconds", expvar.Func(m.certificateExpirySeconds))
+	return m, nil
+}
+
+// ObserveRequest 记录一次API请求
+func (m *ExpvarMetrics) ObserveRequest(ctx context.Context, endpoint, method string, statusCode int, code string, latency time.Duration) {
+	key := method + " " + endpoint
+	m.requests.Add(key, 1)
+	if code != "" {
+		m.errors.Add(key+" "+code, 1)
+	} else if statusCode == 0 || statusCode >= 300 {
+		m.errors.Add(key+" "+strconv.Itoa(statusCode), 1)
+	}
+	m.mu.Lock()
+	histogram, ok := m.latency.Get(key).(*expvar.Map)
+	if !ok {
+		histogram = new(expvar.Map).Init()
+		m.latency.Set(key, histogram)
+	}
+	m.mu.Unlock()
+	bucket := "le_inf"
+	for _, le := range latencyBuckets {
+		if latency <= time.Duration(le)*time.Millisecond {
+			bucket = "le_" + strconv.FormatInt(le, 10)
+			break
+		}
+	}
+	histogram.Add(bucket, 1)
+}
+
+// SignatureFailure 记录一次签名校验失败
+func (m *ExpvarMetrics) SignatureFailure(ctx context.Context, source string) {
+	m.signatureFailures.Add(source, 1)
+}
+
+// CertificateExpiry 记录平台证书的过期时间
+func (m *ExpvarMetrics) CertificateExpiry(serialNo string, expireTime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expireTimes[serialNo] = expireTime
+}
+
+// NotificationProcessed 记录一次回调通知的处理结果
+func (m *ExpvarMetrics) NotificationProcessed(ctx context.Context, eventType, outcome string) {
+	m.notifications.Add(eventType+" "+outcome, 1)
+}
+
+// certificateExpirySeconds 平台证书距离过期的秒数
+func (m *ExpvarMetrics) certificateExpirySeconds() interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := make(map[string]int64, len(m.expireTimes))
+	for serialNo, expireTime := range m.expireTimes {
+		seconds[serialNo] = int64(time.Until(expireTime).Seconds())
+	}
+	return seconds
+}
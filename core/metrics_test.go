@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestEndpointTemplate(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]string{
+		"/v3/pay/transactions/id/4200000985202103031441826014":                    "/v3/pay/transactions/id/{id}",
+		"/v3/merchant-service/complaints-v2/200201820200101080076610000/response": "/v3/merchant-service/complaints-v2/{id}/response",
+		"/v3/certificates": "/v3/certificates",
+	}
+	for path, want := range tests {
+		if got := EndpointTemplate(ctx, path); got != want {
+			t.Errorf("EndpointTemplate(%s) = %s, want %s", path, got, want)
+		}
+	}
+	ctx = WithEndpoint(ctx, "/v3/pay/transactions/out-trade-no/{out_trade_no}")
+	if got := EndpointTemplate(ctx, "/v3/pay/transactions/out-trade-no/abc"); got != "/v3/pay/transactions/out-trade-no/{out_trade_no}" {
+		t.Errorf("EndpointTemplate() with context = %s", got)
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewExpvarMetrics("wechatpay_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewExpvarMetrics("wechatpay_test"); err == nil {
+		t.Errorf("NewExpvarMetrics() with duplicate name should fail")
+	}
+	m.ObserveRequest(ctx, "/v3/refund/domestic/refunds", "POST", 200, "", 80*time.Millisecond)
+	m.ObserveRequest(ctx, "/v3/refund/domestic/refunds", "POST", 403, CodeNotEnough, 30*time.Millisecond)
+	m.SignatureFailure(ctx, SignatureSourceResponse)
+	m.CertificateExpiry("5157F09EFDC096DE15EBE81A47057A7232F1B8E1", time.Now().Add(time.Hour))
+	m.NotificationProcessed(ctx, "REFUND.SUCCESS", NotifySuccess)
+
+	var got struct {
+		Requests          map[string]int64            `json:"requests"`
+		Errors            map[string]int64            `json:"errors"`
+		Latency           map[string]map[string]int64 `json:"latency_ms"`
+		SignatureFailures map[string]int64            `json:"signature_failures"`
+		Notifications     map[string]int64            `json:"notifications"`
+		CertificateExpiry map[string]int64            `json:"certificate_expiry_seconds"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("wechatpay_test").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	key := "POST /v3/refund/domestic/refunds"
+	if got.Requests[key] != 2 || got.Errors[key+" NOT_ENOUGH"] != 1 {
+		t.Errorf("requests = %v, errors = %v", got.Requests, got.Errors)
+	}
+	if got.Latency[key]["le_100"] != 1 || got.Latency[key]["le_50"] != 1 {
+		t.Errorf("latency = %v", got.Latency)
+	}
+	if got.SignatureFailures[SignatureSourceResponse] != 1 || got.Notifications["REFUND.SUCCESS success"] != 1 {
+		t.Errorf("signature failures = %v, notifications = %v", got.SignatureFailures, got.Notifications)
+	}
+	if seconds := got.CertificateExpiry["5157F09EFDC096DE15EBE81A47057A7232F1B8E1"]; seconds < 3500 || seconds > 3600 {
+		t.Errorf("certificate expiry = %d", seconds)
+	}
+}
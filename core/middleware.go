@@ -0,0 +1,85 @@
+// 微信支付api v3 请求中间件
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Exchange 一次已签名的请求及其回包
+type Exchange struct {
+	Method       string         // 请求方法
+	URL          string         // 完整的请求地址
+	Body         string         // 参与签名的请求体
+	Request      *http.Request  // 已签名的http请求，中间件可以添加自定义header
+	Response     *http.Response // http回包，Body 已读取到 ResponseBody
+	ResponseBody []byte         // 回包内容
+	RequestID    string         // 回包header中的Request-Id
+	Latency      time.Duration  // 请求耗时
+}
+
+// RoundTrip 发送已签名的请求，并把回包写入 exchange
+type RoundTrip func(ctx context.Context, exchange *Exchange) error
+
+// Middleware 请求中间件，可以用于日志、监控、链路追踪、添加自定义header等
+//
+// 中间件在请求签名之后、回包校验之前执行，每次重试都会执行；不调用 next 并设置 exchange.Response 即可短路请求，用于测试桩等场景
+type Middleware func(next RoundTrip) RoundTrip
+
+// chain 按添加顺序组合中间件，先添加的中间件在最外层
+func chain(middlewares []Middleware, roundTrip RoundTrip) RoundTrip {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		roundTrip = middlewares[i](roundTrip)
+	}
+	return roundTrip
+}
+
+// httpRoundTrip 使用http client发送请求，读取回包内容并记录耗时
+func httpRoundTrip(hc *http.Client) RoundTrip {
+	return func(ctx context.Context, exchange *Exchange) error {
+		start := time.Now()
+		response, err := hc.Do(exchange.Request)
+		if err != nil {
+			exchange.Latency = time.Since(start)
+			return err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		exchange.Latency = time.Since(start)
+		exchange.Response = response
+		exchange.ResponseBody = body
+		exchange.RequestID = strings.TrimSpace(response.Header.Get(RequestID))
+		if err != nil {
+			return fmt.Errorf("read response body err:[%s]", err.Error())
+		}
+		return nil
+	}
+}
+
+// finish 补全中间件短路时设置的回包，回包 Body 可以重复读取
+func (exchange *Exchange) finish() error {
+	if exchange.Response == nil {
+		return fmt.Errorf("middleware returned without response")
+	}
+	if exchange.ResponseBody == nil && exchange.Response.Body != nil {
+		body, err := ioutil.ReadAll(exchange.Response.Body)
+		exchange.Response.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read response body err:[%s]", err.Error())
+		}
+		exchange.ResponseBody = body
+	}
+	if exchange.Response.Header == nil {
+		exchange.Response.Header = http.Header{}
+	}
+	if exchange.RequestID == "" {
+		exchange.RequestID = strings.TrimSpace(exchange.Response.Header.Get(RequestID))
+	}
+	exchange.Response.Body = ioutil.NopCloser(bytes.NewReader(exchange.ResponseBody))
+	return nil
+}
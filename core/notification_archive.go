@@ -0,0 +1,158 @@
+// 微信支付api v3 回调通知存档
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchivedNotification 存档的回调通知原始请求
+type ArchivedNotification struct {
+	ReceivedAt time.Time   `json:"received_at"` // 收到通知的时间
+	Header     http.Header `json:"header"`      // 验签需要的请求头，包括 Request-ID 及 Wechatpay-*
+	Body       string      `json:"body"`        // 原始请求内容
+}
+
+// NewArchivedNotification 根据收到的回调通知创建存档记录，只保留验签需要的请求头
+func NewArchivedNotification(header http.Header, body []byte) *ArchivedNotification {
+	archived := &ArchivedNotification{ReceivedAt: time.Now(), Header: http.Header{}, Body: string(body)}
+	for key, values := range header {
+		canonicalKey := http.CanonicalHeaderKey(key)
+		if canonicalKey == http.CanonicalHeaderKey(RequestID) || canonicalKey == ContentType ||
+			strings.HasPrefix(canonicalKey, "Wechatpay-") {
+			archived.Header[canonicalKey] = append([]string(nil), values...)
+		}
+	}
+	return archived
+}
+
+// NotificationArchive 回调通知存档，用于问题修复后回放历史通知
+type NotificationArchive interface {
+	Append(ctx context.Context, notification *ArchivedNotification) error
+}
+
+// FileNotificationArchive 以 JSON Lines 格式追加写入文件的回调通知存档
+type FileNotificationArchive struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileNotificationArchive 打开 path 对应的存档文件，文件不存在时创建
+func NewFileNotificationArchive(path string) (*FileNotificationArchive, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNotificationArchive{file: file}, nil
+}
+
+// Append 追加一条存档记录
+func (a *FileNotificationArchive) Append(ctx context.Context, notification *ArchivedNotification) error {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err = a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close 关闭存档文件
+func (a *FileNotificationArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// ReadNotificationArchive 按顺序读取存档中的记录，fn 返回错误时停止读取
+func ReadNotificationArchive(r io.Reader, fn func(notification *ArchivedNotification) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			notification := &ArchivedNotification{}
+			if jerr := json.Unmarshal(line, notification); jerr != nil {
+				return jerr
+			}
+			if ferr := fn(notification); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type replayKey struct{}
+
+// WithReplay 标记正在回放存档的历史通知，校验时跳过时间戳检查，但仍然校验签名
+func WithReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey{}, true)
+}
+
+// isReplay 是否正在回放历史通知
+func isReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey{}).(bool)
+	return replay
+}
+
+// 回放请求的header，wechatpay-notify replay 使用与服务共享的 secret 签名
+const (
+	HeaderReplayTimestamp = "X-Wechatpay-Replay-Timestamp" // 回放请求的时间戳，秒
+	HeaderReplaySignature = "X-Wechatpay-Replay-Signature" // sha256=<hex(HMAC-SHA256(secret, timestamp + "\n" + body))>
+)
+
+// replaySignature 计算回放请求的签名
+func replaySignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(timestamp + "\n"))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignReplayRequest 使用共享的 secret 对回放请求签名，设置 HeaderReplayTimestamp 及 HeaderReplaySignature
+func SignReplayRequest(header http.Header, secret, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	header.Set(HeaderReplayTimestamp, timestamp)
+	header.Set(HeaderReplaySignature, replaySignature(secret, timestamp, body))
+}
+
+// VerifyReplayRequest 校验回放请求的签名，请求时间戳与 now 的偏差不能超过 maxAge
+func VerifyReplayRequest(header http.Header, secret, body []byte, now time.Time, maxAge time.Duration) error {
+	timestamp := strings.TrimSpace(header.Get(HeaderReplayTimestamp))
+	signature := strings.TrimSpace(header.Get(HeaderReplaySignature))
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("empty %s or %s", HeaderReplayTimestamp, HeaderReplaySignature)
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid replay timestamp:[%s] err:[%v]", timestamp, err)
+	}
+	if math.Abs(float64(now.Unix()-unix)) > maxAge.Seconds() {
+		return fmt.Errorf("replay timestamp=[%s] expires", timestamp)
+	}
+	if !hmac.Equal([]byte(signature), []byte(replaySignature(secret, timestamp, body))) {
+		return fmt.Errorf("replay signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+// 微信支付api v3 回调通知去重
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultNotificationTTL 通知去重记录的默认保留时间，覆盖微信支付24小时内的通知重试
+const DefaultNotificationTTL = 48 * time.Hour
+
+// DefaultReserveTTL 预占的默认有效时间，进程在处理中途退出等未释放的预占过期后，微信支付重试时可以重新处理
+const DefaultReserveTTL = 5 * time.Minute
+
+// 通知去重错误
+var (
+	ErrNotificationDuplicate  = errors.New("wechatpay: notification already processed")
+	ErrNotificationInProgress = errors.New("wechatpay: notification in progress")
+)
+
+// NotificationStore 回调通知去重存储，以通知ID及请求头中的 Wechatpay-Nonce 为键
+//
+// 处理通知前调用 Reserve 预占，处理成功后调用 Commit，失败时调用 Release 以便微信支付重试时重新处理
+type NotificationStore interface {
+	// Reserve 预占通知，ID或nonce已处理过时返回 ErrNotificationDuplicate，正在处理时返回 ErrNotificationInProgress
+	Reserve(ctx context.Context, id, nonce string) error
+	// Commit 通知处理成功，保留时间内再次收到相同ID或nonce的通知视为重复
+	Commit(ctx context.Context, id, nonce string) error
+	// Release 通知处理失败，释放预占
+	Release(ctx context.Context, id, nonce string) error
+}
+
+// notificationKeys 通知的去重键
+func notificationKeys(id, nonce string) []string {
+	keys := make([]string, 0, 2)
+	if id != "" {
+		keys = append(keys, "id:"+id)
+	}
+	if nonce != "" {
+		keys = append(keys, "nonce:"+nonce)
+	}
+	return keys
+}
+
+// MemoryNotificationStore 内存中的通知去重存储，进程重启后记录丢失，多实例部署时需要使用共享存储
+type MemoryNotificationStore struct {
+	TTL        time.Duration // 保留时间，为0时使用 DefaultNotificationTTL
+	ReserveTTL time.Duration // 预占的有效时间，应大于处理器的最长处理时间，为0时使用 DefaultReserveTTL
+	Clock      Clock         // 判断记录是否过期的时钟，为空时使用系统时钟
+
+	mu        sync.Mutex
+	committed map[string]time.Time // 已处理的键 -> 过期时间
+	reserved  map[string]time.Time // 正在处理的键 -> 预占过期时间
+	nextSweep time.Time            // 下次清理过期记录的时间
+}
+
+// NewMemoryNotificationStore 创建内存中的通知去重存储，ttl 为0时使用 DefaultNotificationTTL
+func NewMemoryNotificationStore(ttl time.Duration) *MemoryNotificationStore {
+	return &MemoryNotificationStore{TTL: ttl}
+}
+
+func (s *MemoryNotificationStore) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return DefaultNotificationTTL
+	}
+	return s.TTL
+}
+
+func (s *MemoryNotificationStore) reserveTTL() time.Duration {
+	if s.ReserveTTL <= 0 {
+		return DefaultReserveTTL
+	}
+	return s.ReserveTTL
+}
+
+// Reserve 预占通知，预占在 ReserveTTL 后过期
+func (s *MemoryNotificationStore) Reserve(ctx context.Context, id, nonce string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := now(s.Clock)
+	s.sweep(now)
+	keys := notificationKeys(id, nonce)
+	for _, key := range keys {
+		if expireTime, ok := s.committed[key]; ok && now.Before(expireTime) {
+			return ErrNotificationDuplicate
+		}
+	}
+	for _, key := range keys {
+		if expireTime, ok := s.reserved[key]; ok && now.Before(expireTime) {
+			return ErrNotificationInProgress
+		}
+	}
+	if s.reserved == nil {
+		s.reserved = make(map[string]time.Time)
+	}
+	for _, key := range keys {
+		s.reserved[key] = now.Add(s.reserveTTL())
+	}
+	return nil
+}
+
+// Commit 通知处理成功
+func (s *MemoryNotificationStore) Commit(ctx context.Context, id, nonce string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commit(notificationKeys(id, nonce), now(s.Clock).Add(s.ttl()))
+	return nil
+}
+
+func (s *MemoryNotificationStore) commit(keys []string, expireTime time.Time) {
+	if s.committed == nil {
+		s.committed = make(map[string]time.Time)
+	}
+	for _, key := range keys {
+		delete(s.reserved, key)
+		s.committed[key] = expireTime
+	}
+}
+
+// Release 通知处理失败，释放预占
+func (s *MemoryNotificationStore) Release(ctx context.Context, id, nonce string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range notificationKeys(id, nonce) {
+		delete(s.reserved, key)
+	}
+	return nil
+}
+
+// sweep 定期清理过期记录
+func (s *MemoryNotificationStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for key, expireTime := range s.committed {
+		if !now.Before(expireTime) {
+			delete(s.committed, key)
+		}
+	}
+	for key, expireTime := range s.reserved {
+		if !now.Before(expireTime) {
+			delete(s.reserved, key)
+		}
+	}
+	s.nextSweep = now.Add(time.Minute)
+}
+
+// FileNotificationStore 文件持久化的通知去重存储，进程重启后已处理的记录仍然有效
+//
+// 已处理的记录以 JSON Lines 格式追加写入文件，打开时加载未过期的记录并重写文件以清理过期记录；
+// 预占只保存在内存中，同样在 ReserveTTL 后过期
+type FileNotificationStore struct {
+	MemoryNotificationStore
+
+	fileMu sync.Mutex
+	file   *os.File
+}
+
+// fileNotificationRecord 文件中的一条已处理记录
+type fileNotificationRecord struct {
+	Keys       []string  `json:"keys"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// NewFileNotificationStore 打开 path 对应的通知去重存储，文件不存在时创建，ttl 为0时使用 DefaultNotificationTTL
+func NewFileNotificationStore(path string, ttl time.Duration) (*FileNotificationStore, error) {
+	s := &FileNotificationStore{MemoryNotificationStore: MemoryNotificationStore{TTL: ttl}}
+	records, err := readNotificationRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(tmp)
+	for _, record := range records {
+		if !now.Before(record.ExpireTime) {
+			continue
+		}
+		s.commit(record.Keys, record.ExpireTime)
+		if err = encoder.Encode(record); err != nil {
+			_ = tmp.Close()
+			return nil, err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+	if s.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readNotificationRecords 读取文件中的已处理记录，忽略进程异常退出时写了一半的记录
+func readNotificationRecords(path string) ([]fileNotificationRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []fileNotificationRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record fileNotificationRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Commit 通知处理成功，写入文件后才视为已处理
+func (s *FileNotificationStore) Commit(ctx context.Context, id, nonce string) error {
+	record := fileNotificationRecord{Keys: notificationKeys(id, nonce), ExpireTime: now(s.Clock).Add(s.ttl())}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.fileMu.Lock()
+	_, err = s.file.Write(append(line, '\n'))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	s.fileMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commit(record.Keys, record.ExpireTime)
+	return err
+}
+
+// Close 关闭文件
+func (s *FileNotificationStore) Close() error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	return s.file.Close()
+}
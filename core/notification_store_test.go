@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileNotificationStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	store, err := NewFileNotificationStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Reserve(ctx, "EV-1", "N1"); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err = store.Reserve(ctx, "EV-1", "N2"); !errors.Is(err, ErrNotificationInProgress) {
+		t.Errorf("Reserve() in progress error = %v", err)
+	}
+	if err = store.Release(ctx, "EV-1", "N1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Reserve(ctx, "EV-1", "N2"); err != nil {
+		t.Fatalf("Reserve() after release error = %v", err)
+	}
+	if err = store.Commit(ctx, "EV-1", "N2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后已处理的通知ID及nonce仍视为重复
+	store, err = NewFileNotificationStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.Reserve(ctx, "EV-1", "N3"); !errors.Is(err, ErrNotificationDuplicate) {
+		t.Errorf("Reserve() same id error = %v", err)
+	}
+	if err = store.Reserve(ctx, "EV-2", "N2"); !errors.Is(err, ErrNotificationDuplicate) {
+		t.Errorf("Reserve() same nonce error = %v", err)
+	}
+	if err = store.Reserve(ctx, "EV-2", "N4"); err != nil {
+		t.Errorf("Reserve() new notification error = %v", err)
+	}
+}
+
+func TestMemoryNotificationStoreReserveTTL(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryNotificationStore(time.Hour)
+	store.ReserveTTL = 20 * time.Millisecond
+	store.Clock = ClockFunc(func() time.Time { return now })
+	if err := store.Reserve(ctx, "EV-1", "N1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reserve(ctx, "EV-1", "N2"); !errors.Is(err, ErrNotificationInProgress) {
+		t.Errorf("Reserve() in progress error = %v", err)
+	}
+	// 未释放的预占过期后可以重新处理
+	now = now.Add(30 * time.Millisecond)
+	if err := store.Reserve(ctx, "EV-1", "N2"); err != nil {
+		t.Errorf("Reserve() after reservation expired error = %v", err)
+	}
+}
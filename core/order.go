@@ -3,7 +3,6 @@ package core
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
 
 	"github.com/perlyna/wechatpay/model"
@@ -12,11 +11,17 @@ import (
 // OrderQuery 查询订单API
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_2.shtml
 func OrderQuery(ctx context.Context, hc *http.Client, reqURL string, credential Credential, validator Validator) (model.TradeQuery, error) {
+	return NewClient(hc, credential, validator).OrderQuery(ctx, reqURL)
+}
+
+// OrderQuery 查询订单API
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_2.shtml
+func (c *Client) OrderQuery(ctx context.Context, reqURL string) (model.TradeQuery, error) {
 	var tradeQuery model.TradeQuery
-	body, err := Get(ctx, hc, credential, validator, reqURL)
+	body, err := c.Get(ctx, reqURL)
 	if err != nil {
 		return tradeQuery, err
 	}
-	err = json.Unmarshal(body, &tradeQuery)
+	err = c.Unmarshal(ctx, body, &tradeQuery)
 	return tradeQuery, err
 }
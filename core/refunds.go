@@ -2,20 +2,30 @@ package core
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
 
 	"github.com/perlyna/wechatpay/model"
 )
 
-const refundsURL = `https://api.mch.weixin.qq.com/v3/refund/domestic/refunds`
+const refundsURL = `/v3/refund/domestic/refunds`
 
 func Refunds(ctx context.Context, hc *http.Client, refundsReq model.RefundsReq, credential Credential, validator Validator) (model.RefundsOrder, error) {
+	return NewClient(hc, credential, validator).Refunds(ctx, refundsReq)
+}
+
+// Refunds 申请退款API
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_9.shtml
+//
+// 商户退款单号相同的退款请求是幂等的，可以按重试策略重试
+func (c *Client) Refunds(ctx context.Context, refundsReq model.RefundsReq) (model.RefundsOrder, error) {
 	var refundsOrder model.RefundsOrder
-	body, err := Post(ctx, hc, credential, validator, refundsURL, refundsReq)
+	if refundsReq.OutRefundNo != "" {
+		ctx = WithIdempotent(ctx)
+	}
+	body, err := c.Post(ctx, refundsURL, refundsReq)
 	if err != nil {
 		return refundsOrder, err
 	}
-	err = json.Unmarshal(body, &refundsOrder)
+	err = c.Unmarshal(ctx, body, &refundsOrder)
 	return refundsOrder, err
 }
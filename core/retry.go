@@ -0,0 +1,94 @@
+// 微信支付api v3 请求重试策略
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy 请求重试策略
+//
+// 网络错误、5xx回包以及 SYSTEM_ERROR、FREQUENCY_LIMITED 等可重试错误会按指数退避重试，每次重试都会重新生成签名和随机字符串。
+// GET 请求总是可以重试，其他请求只有通过 WithIdempotent 标记为幂等时才会重试。
+type RetryPolicy struct {
+	MaxAttempts    int           // 最多尝试次数（包含第一次请求），小于等于1时不重试
+	InitialBackoff time.Duration // 第一次重试前的等待时间
+	MaxBackoff     time.Duration // 最长等待时间，为0时不限制
+	Multiplier     float64       // 等待时间的增长倍数，小于1时使用2
+	Jitter         float64       // 等待时间的随机抖动比例，取值0-1，0表示不抖动
+	AttemptTimeout time.Duration // 单次请求的超时时间，为0时仅受ctx控制
+}
+
+// DefaultRetryPolicy 默认重试策略：最多3次，100ms起按2倍退避，最长2s，50%抖动，单次请求10s超时
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+		AttemptTimeout: 10 * time.Second,
+	}
+}
+
+// maxAttempts 请求的最多尝试次数
+func (p *RetryPolicy) maxAttempts(ctx context.Context, method string) int {
+	if p == nil || p.MaxAttempts <= 1 {
+		return 1
+	}
+	if method != http.MethodGet && !isIdempotent(ctx) {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff 第 attempt 次请求失败后，重试前的等待时间
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff -= backoff * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+// shouldRetry 请求失败后是否重试，ctx 已取消时不再重试；单次请求超时可以重试
+func (p *RetryPolicy) shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return IsRetryable(err)
+}
+
+// attemptContext 单次请求的ctx
+func (p *RetryPolicy) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p == nil || p.AttemptTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.AttemptTimeout)
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent 标记请求为幂等请求，使用相同参数重复请求不会产生副作用（如使用固定商户退款单号的退款），可以按重试策略重试
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
@@ -0,0 +1,134 @@
+// 微信支付api v3 商户证书轮换签名器
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingKey 轮换签名器中的一个商户证书
+type rotatingKey struct {
+	serialNo   string    // 商户证书序列号
+	signer     Signer    // 签名器
+	activeFrom time.Time // 启用时间
+}
+
+// RotatingSigner 支持商户证书轮换的签名器
+//
+// 持有多个商户证书的签名器，按启用时间选择当前生效的签名器，新旧证书可以在运行时增删，不需要重新创建WechatPay
+type RotatingSigner struct {
+	mu    sync.RWMutex
+	keys  []rotatingKey // 按启用时间升序排列
+	clock Clock         // 判断是否到达启用时间的时钟，为空时使用系统时钟
+}
+
+// NewRotatingSigner 创建轮换签名器，signer 立即启用
+func NewRotatingSigner(serialNo string, signer Signer) *RotatingSigner {
+	s := &RotatingSigner{}
+	s.AddSigner(serialNo, signer, time.Time{})
+	return s
+}
+
+// SetClock 设置判断是否到达启用时间的时钟，测试时可以注入固定时间验证证书切换
+func (s *RotatingSigner) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// AddSigner 添加一个商户证书签名器，在 activeFrom 之后启用；序列号已存在时替换原有签名器
+func (s *RotatingSigner) AddSigner(serialNo string, signer Signer, activeFrom time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]rotatingKey, 0, len(s.keys)+1)
+	for _, key := range s.keys {
+		if key.serialNo != serialNo {
+			keys = append(keys, key)
+		}
+	}
+	keys = append(keys, rotatingKey{serialNo: serialNo, signer: signer, activeFrom: activeFrom})
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].activeFrom.Before(keys[j].activeFrom) })
+	s.keys = keys
+}
+
+// RemoveSigner 移除一个商户证书签名器，通常在旧证书过期后调用
+func (s *RotatingSigner) RemoveSigner(serialNo string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]rotatingKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.serialNo != serialNo {
+			keys = append(keys, key)
+		}
+	}
+	s.keys = keys
+}
+
+// SerialNos 返回所有商户证书序列号，按启用时间升序排列
+func (s *RotatingSigner) SerialNos() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	serialNos := make([]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		serialNos = append(serialNos, key.serialNo)
+	}
+	return serialNos
+}
+
+// active 返回当前生效的签名器：已启用的签名器中启用时间最晚的一个
+func (s *RotatingSigner) active() (rotatingKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	current := now(s.clock)
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if !s.keys[i].activeFrom.After(current) {
+			return s.keys[i], true
+		}
+	}
+	return rotatingKey{}, false
+}
+
+// ActiveSerialNo 返回当前生效的商户证书序列号
+func (s *RotatingSigner) ActiveSerialNo() string {
+	key, _ := s.active()
+	return key.serialNo
+}
+
+// GetName 获取当前生效签名器的名称
+func (s *RotatingSigner) GetName() string {
+	if key, ok := s.active(); ok {
+		return key.signer.GetName()
+	}
+	return SHA256WithRSA
+}
+
+// 获取签名器的类型
+func (s *RotatingSigner) GetType() string {
+	return "ROTATING"
+}
+
+// 获取签名器的版本
+func (s *RotatingSigner) GetVersion() string {
+	return "1.0"
+}
+
+// Sign 使用当前生效的签名器对信息进行签名，签名结果中的序列号及签名算法即本次使用的商户证书及其算法
+//
+// 生效的签名器在每次签名时只选择一次，证书切换时认证类型与签名不会错配，调用方应使用 SignatureResult.Algorithm 而不是 GetName
+func (s *RotatingSigner) Sign(ctx context.Context, message string) (*SignatureResult, error) {
+	key, ok := s.active()
+	if !ok {
+		return nil, fmt.Errorf("there is no active signer in RotatingSigner")
+	}
+	result, err := key.signer.Sign(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if result.Algorithm == "" {
+		result.Algorithm = key.signer.GetName()
+	}
+	return result, nil
+}
@@ -9,12 +9,15 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+
+	"github.com/tjfoc/gmsm/sm2"
 )
 
 // SignatureResult 签名结果
 type SignatureResult struct {
 	MchCertificateSerialNo string // 商户序列号
 	Signature              string // 签名
+	Algorithm              string // 签名算法 SHA256withRSA 或 SM2withSM3，为空时由 Signer.GetName 决定
 }
 
 // Signer 签名生成器
@@ -33,7 +36,7 @@ type SHA256WithRSASigner struct {
 
 // GetName 获取签名器的名称
 func (s *SHA256WithRSASigner) GetName() string {
-	return "SHA256withRSA"
+	return SHA256WithRSA
 }
 
 // 获取签名器的类型
@@ -63,7 +66,45 @@ func (s *SHA256WithRSASigner) Sign(ctx context.Context, message string) (*Signat
 	if err != nil {
 		return nil, err
 	}
-	ret := &SignatureResult{MchCertificateSerialNo: s.MchCertificateSerialNo}
+	ret := &SignatureResult{MchCertificateSerialNo: s.MchCertificateSerialNo, Algorithm: s.GetName()}
+	ret.Signature = base64.StdEncoding.EncodeToString(signatureByte)
+	return ret, nil
+}
+
+// SM2WithSM3Signer SM2WithSM3 签名器（国密）
+type SM2WithSM3Signer struct {
+	MchCertificateSerialNo string          // 商户证书序列号
+	PrivateKey             *sm2.PrivateKey // 商户SM2私钥
+}
+
+// GetName 获取签名器的名称
+func (s *SM2WithSM3Signer) GetName() string {
+	return SM2WithSM3
+}
+
+// 获取签名器的类型
+func (s *SM2WithSM3Signer) GetType() string {
+	return "PRIVATEKEY"
+}
+
+// 获取签名器的版本
+func (s *SM2WithSM3Signer) GetVersion() string {
+	return "1.0"
+}
+
+// 对信息使用SM2WithSM3的方式进行签名
+func (s *SM2WithSM3Signer) Sign(ctx context.Context, message string) (*SignatureResult, error) {
+	if s.PrivateKey == nil {
+		return nil, fmt.Errorf("you must set privatekey to use SM2WithSM3Signer")
+	}
+	if strings.TrimSpace(s.MchCertificateSerialNo) == "" {
+		return nil, fmt.Errorf("you must set mch certificate serial no to use SM2WithSM3Signer")
+	}
+	signatureByte, err := s.PrivateKey.Sign(rand.Reader, []byte(message), nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := &SignatureResult{MchCertificateSerialNo: s.MchCertificateSerialNo, Algorithm: s.GetName()}
 	ret.Signature = base64.StdEncoding.EncodeToString(signatureByte)
 	return ret, nil
 }
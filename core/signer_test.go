@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+func TestSM2WithSM3Signer(t *testing.T) {
+	ctx := context.Background()
+	privateKey, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &SM2WithSM3Signer{MchCertificateSerialNo: "SM2SERIAL", PrivateKey: privateKey}
+	message := "GET\n/v3/certificates\n1554208460\n593BEC0C930BF1AFEB40B4A08C8FB242\n\n"
+	result, err := signer.Sign(ctx, message)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(result.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &SM2WithSM3Verifier{Certificates: map[string]*x509.Certificate{
+		"SM2SERIAL": {PublicKey: &privateKey.PublicKey},
+	}}
+	if err = verifier.Verify(ctx, "SM2SERIAL", message, string(signature)); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err = verifier.Verify(ctx, "SM2SERIAL", message+"tampered", string(signature)); err == nil {
+		t.Errorf("Verify() tampered message should fail")
+	}
+	rsaVerifier := &WechatPayVerifier{Certificates: verifier.Certificates}
+	if err = rsaVerifier.Verify(ctx, "SM2SERIAL", message, string(signature)); err == nil {
+		t.Errorf("WechatPayVerifier.Verify() with sm2 certificate should fail")
+	}
+
+	credential := &WechatPayCredentials{Signer: signer, MchID: "1900009191"}
+	authorization, err := credential.GenerateAuthorizationHeader(ctx, "GET", "/v3/certificates", "")
+	if err != nil {
+		t.Fatalf("GenerateAuthorizationHeader() error = %v", err)
+	}
+	if !strings.HasPrefix(authorization, SchemaSM2WithSM3+" ") {
+		t.Errorf("GenerateAuthorizationHeader() = %s, want schema %s", authorization, SchemaSM2WithSM3)
+	}
+}
+
+// fakeKeyService 模拟的远程密钥服务，私钥不出服务
+type fakeKeyService struct {
+	privateKey *rsa.PrivateKey
+}
+
+func (k *fakeKeyService) Public() crypto.PublicKey {
+	return &k.privateKey.PublicKey
+}
+
+func (k *fakeKeyService) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return rsa.SignPKCS1v15(rand.Reader, k.privateKey, opts.HashFunc(), digest)
+}
+
+func TestCryptoSignerAndRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyService := &fakeKeyService{privateKey: privateKey}
+	verifier := &WechatPayVerifier{Certificates: map[string]*x509.Certificate{
+		"RSASERIAL": {PublicKey: &privateKey.PublicKey},
+	}}
+	message := "POST\n/v3/refund/domestic/refunds\n1554208460\n593BEC0C930BF1AFEB40B4A08C8FB242\n{}\n"
+
+	signers := []Signer{
+		&CryptoSigner{MchCertificateSerialNo: "RSASERIAL", Signer: keyService},
+		&RemoteSigner{MchCertificateSerialNo: "RSASERIAL", Timeout: time.Second,
+			SignFunc: func(ctx context.Context, digest []byte) ([]byte, error) {
+				return keyService.Sign(nil, digest, crypto.SHA256)
+			}},
+	}
+	for _, signer := range signers {
+		result, err := signer.Sign(ctx, message)
+		if err != nil {
+			t.Fatalf("%T Sign() error = %v", signer, err)
+		}
+		signature, _ := base64.StdEncoding.DecodeString(result.Signature)
+		if err = verifier.Verify(ctx, result.MchCertificateSerialNo, message, string(signature)); err != nil {
+			t.Errorf("%T Verify() error = %v", signer, err)
+		}
+	}
+
+	slow := &RemoteSigner{MchCertificateSerialNo: "RSASERIAL", Timeout: 10 * time.Millisecond,
+		SignFunc: func(ctx context.Context, digest []byte) ([]byte, error) {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			return nil, nil
+		}}
+	if _, err = slow.Sign(ctx, message); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RemoteSigner Sign() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRotatingSigner(t *testing.T) {
+	ctx := context.Background()
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signer := NewRotatingSigner("OLD", &SHA256WithRSASigner{MchCertificateSerialNo: "OLD", PrivateKey: oldKey})
+	signer.AddSigner("NEW", &SHA256WithRSASigner{MchCertificateSerialNo: "NEW", PrivateKey: newKey}, time.Now().Add(time.Hour))
+
+	result, err := signer.Sign(ctx, "message")
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if result.MchCertificateSerialNo != "OLD" || signer.ActiveSerialNo() != "OLD" {
+		t.Errorf("before cut-over serial = %s, want OLD", result.MchCertificateSerialNo)
+	}
+
+	signer.AddSigner("NEW", &SHA256WithRSASigner{MchCertificateSerialNo: "NEW", PrivateKey: newKey}, time.Now().Add(-time.Second))
+	if result, _ = signer.Sign(ctx, "message"); result.MchCertificateSerialNo != "NEW" {
+		t.Errorf("after cut-over serial = %s, want NEW", result.MchCertificateSerialNo)
+	}
+
+	signer.RemoveSigner("NEW")
+	if signer.ActiveSerialNo() != "OLD" {
+		t.Errorf("after remove serial = %s, want OLD", signer.ActiveSerialNo())
+	}
+
+	// 切换到国密证书后，认证类型由本次签名使用的签名器决定
+	sm2Key, _ := sm2.GenerateKey(rand.Reader)
+	signer.AddSigner("SM2", &SM2WithSM3Signer{MchCertificateSerialNo: "SM2", PrivateKey: sm2Key}, time.Now().Add(-time.Second))
+	if result, _ = signer.Sign(ctx, "message"); result.Algorithm != SM2WithSM3 || result.MchCertificateSerialNo != "SM2" {
+		t.Errorf("Sign() = %+v, want SM2 result", result)
+	}
+	credential := &WechatPayCredentials{Signer: signer, MchID: "1900009191"}
+	authorization, err := credential.GenerateAuthorizationHeader(ctx, "GET", "/v3/certificates", "")
+	if err != nil || !strings.HasPrefix(authorization, SchemaSM2WithSM3+" ") || !strings.Contains(authorization, `serial_no="SM2"`) {
+		t.Errorf("GenerateAuthorizationHeader() = %s, %v", authorization, err)
+	}
+}
@@ -0,0 +1,79 @@
+// 微信支付api v3 链路追踪
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// 链路追踪 span 名称
+const (
+	SpanRequest      = "wechatpay.request"      // 一次API调用，包含重试
+	SpanNotification = "wechatpay.notification" // 一次回调通知处理
+)
+
+// span 属性
+const (
+	AttrEndpoint   = "wechatpay.endpoint"   // 去掉ID的地址模板
+	AttrMethod     = "http.method"          // http 方法
+	AttrMchID      = "wechatpay.mchid"      // 商户号
+	AttrStatusCode = "http.status_code"     // http 状态码
+	AttrRequestID  = "wechatpay.request_id" // 微信支付 Request-Id
+	AttrErrorCode  = "wechatpay.error_code" // 微信支付错误码
+	AttrAttempt    = "wechatpay.attempt"    // 第几次尝试
+	AttrEventID    = "wechatpay.event_id"   // 通知ID
+	AttrEventType  = "wechatpay.event_type" // 通知类型
+)
+
+// Tracer 链路追踪接口，可以桥接到 OpenTelemetry 等任意追踪系统，SDK 本身不依赖具体实现
+type Tracer interface {
+	// Start 开始一个 span，返回的 ctx 中应携带该 span 以便传播
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 链路追踪中的一个 span
+type Span interface {
+	// SetAttribute 设置属性，value 为 string、int 等基础类型
+	SetAttribute(key string, value interface{})
+	// RecordError 记录错误
+	RecordError(err error)
+	// End 结束 span
+	End()
+}
+
+type spanKey struct{}
+
+// StartSpan 使用 tracer 开始一个 span，tracer 为空时返回不做任何事的 span
+func StartSpan(ctx context.Context, tracer Tracer, name string) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := tracer.Start(ctx, name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext 获取 ctx 中由 StartSpan 开始的 span，不存在时返回不做任何事的 span
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
+
+// EndSpan 记录错误码及错误后结束 span
+func EndSpan(span Span, err error) {
+	if err != nil {
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			span.SetAttribute(AttrErrorCode, apiErr.Code)
+		}
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+type recordingSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *recordingSpan) RecordError(err error)                      { s.err = err }
+func (s *recordingSpan) End()                                       { s.ended = true }
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordingSpan{name: name, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestClientTracing(t *testing.T) {
+	merchantKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	stub := func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, exchange *Exchange) error {
+			header := http.Header{}
+			header.Set(RequestID, "STUB-REQUEST-ID")
+			exchange.Response = &http.Response{StatusCode: http.StatusNotFound, Header: header}
+			exchange.ResponseBody = []byte(`{"code":"ORDER_NOT_EXIST","message":"订单不存在"}`)
+			return nil
+		}
+	}
+	tracer := &recordingTracer{}
+	client := &Client{
+		Credential: &WechatPayCredentials{MchID: "1900009191",
+			Signer: &SHA256WithRSASigner{MchCertificateSerialNo: "MCH", PrivateKey: merchantKey}},
+		Validator:   WithoutValidator,
+		Middlewares: []Middleware{stub},
+		Tracer:      tracer,
+	}
+	_, err := client.OrderQuery(context.Background(), "/v3/pay/transactions/id/4200000985202103031441826014?mchid=1900009191")
+	if !errors.Is(err, ErrOrderNotExist) {
+		t.Fatalf("OrderQuery() error = %v", err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("spans = %d, want 1", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	want := map[string]interface{}{
+		AttrEndpoint:   "/v3/pay/transactions/id/{id}",
+		AttrMethod:     http.MethodGet,
+		AttrMchID:      "1900009191",
+		AttrStatusCode: http.StatusNotFound,
+		AttrRequestID:  "STUB-REQUEST-ID",
+		AttrErrorCode:  CodeOrderNotExist,
+		AttrAttempt:    1,
+	}
+	for key, value := range want {
+		if span.attrs[key] != value {
+			t.Errorf("attribute %s = %v, want %v", key, span.attrs[key], value)
+		}
+	}
+	if span.name != SpanRequest || !span.ended || span.err == nil {
+		t.Errorf("span = %+v", span)
+	}
+}
@@ -29,7 +29,9 @@ var WithoutValidator = &WechatPayNullValidator{}
 
 // WechatPayValidator 回包校验器
 type WechatPayValidator struct {
-	Verifier Verifier // 验证器
+	Verifier Verifier      // 验证器
+	Clock    Clock         // 校验时间戳的时钟，为空时使用系统时钟
+	MaxSkew  time.Duration // 回包时间戳与当前时间允许的最大偏差，为0时为5分钟
 }
 
 // Validate 使用验证器对回包进行校验
@@ -37,16 +39,20 @@ func (validator *WechatPayValidator) Validate(ctx context.Context, body []byte,
 	if validator.Verifier == nil {
 		return fmt.Errorf("you must init WechatPayValidator with auth.Verifier")
 	}
-	err := validateParameters(header)
-	if err != nil {
-		return err
-	}
 	message, err := buildMessage(body, header)
 	if err != nil {
 		return err
 	}
 	// 微信支付回包平台序列号
 	serialNumber := strings.TrimSpace(header.Get(WechatPaySerial))
+	if d := signatureDiagnosticsFromContext(ctx); d != nil {
+		d.VerifyMessage, d.ResponseSerial = message, serialNumber
+		d.ResponseSignature = strings.TrimSpace(header.Get(WechatPaySignature))
+		d.RequestID = strings.TrimSpace(header.Get(RequestID))
+	}
+	if err = validator.validateParameters(ctx, header); err != nil {
+		return err
+	}
 	// 微信支付回包签名信息
 	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header.Get(WechatPaySignature)))
 	if err != nil {
@@ -60,7 +66,7 @@ func (validator *WechatPayValidator) Validate(ctx context.Context, body []byte,
 	return nil
 }
 
-func validateParameters(header http.Header) (err error) {
+func (validator *WechatPayValidator) validateParameters(ctx context.Context, header http.Header) (err error) {
 	// 微信支付回包请求ID
 	requestID := strings.TrimSpace(header.Get(RequestID))
 	if requestID == "" {
@@ -87,7 +93,12 @@ func validateParameters(header http.Header) (err error) {
 	if err != nil {
 		return fmt.Errorf("invalid timestamp:[%s] request-id=[%s] err:[%v]", timeStampStr, requestID, err)
 	}
-	if math.Abs(float64(timeStamp)-float64(time.Now().Unix())) >= FiveMinute {
+	// 回放历史通知时不检查时间戳
+	maxSkew := validator.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = FiveMinute * time.Second
+	}
+	if !isReplay(ctx) && math.Abs(float64(timeStamp)-float64(now(validator.Clock).Unix())) >= maxSkew.Seconds() {
 		return fmt.Errorf("timestamp=[%d] expires, request-id=[%s]", timeStamp, requestID)
 	}
 	return nil
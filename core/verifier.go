@@ -8,6 +8,8 @@ import (
 	"crypto/x509"
 	"fmt"
 	"strings"
+
+	"github.com/tjfoc/gmsm/sm2"
 )
 
 // Verifier 验证器
@@ -58,10 +60,45 @@ func (verifier *WechatPayVerifier) Verify(ctx context.Context, serialNumber, mes
 	if !ok {
 		return fmt.Errorf("no serial number:%s corresponding certificate ", serialNumber)
 	}
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		if _, ok = certificate.PublicKey.(*sm2.PublicKey); ok {
+			return fmt.Errorf("certificate serial number:%s is sm2 certificate, use SM2WithSM3Verifier", serialNumber)
+		}
+		return fmt.Errorf("certificate serial number:%s is not rsa certificate", serialNumber)
+	}
 	hashed := sha256.Sum256([]byte(message))
-	err = rsa.VerifyPKCS1v15(certificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, hashed[:], []byte(signature))
+	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], []byte(signature))
 	if err != nil {
 		return fmt.Errorf("verifty signature with public key err:%s", err.Error())
 	}
 	return nil
 }
+
+// SM2WithSM3Verifier 微信支付国密验证器
+type SM2WithSM3Verifier struct {
+	Certificates map[string]*x509.Certificate // key 微信支付平台证书序列号 value 微信支付SM2平台证书 （可通过util.LoadSM2Certificate加载）
+}
+
+// Verify 使用SM2平台证书对回包中的签名信息进行验证
+func (verifier *SM2WithSM3Verifier) Verify(ctx context.Context, serialNumber, message, signature string) error {
+	err := checkParameter(ctx, serialNumber, message, signature)
+	if err != nil {
+		return err
+	}
+	if verifier.Certificates == nil {
+		return fmt.Errorf("there is no certificate in wechat pay verifier")
+	}
+	certificate, ok := verifier.Certificates[serialNumber]
+	if !ok {
+		return fmt.Errorf("no serial number:%s corresponding certificate ", serialNumber)
+	}
+	publicKey, ok := certificate.PublicKey.(*sm2.PublicKey)
+	if !ok {
+		return fmt.Errorf("certificate serial number:%s is not sm2 certificate", serialNumber)
+	}
+	if !publicKey.Verify([]byte(message), []byte(signature)) {
+		return fmt.Errorf("verifty signature with sm2 public key fail")
+	}
+	return nil
+}
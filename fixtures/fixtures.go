@@ -0,0 +1,199 @@
+// Package fixtures 测试用的商户密钥及证书、平台密钥及证书、APIv3密钥，以及加密的通知资源和签名的回调通知请求
+//
+// SDK 及下游服务的测试可以用它生成真实加密、真实签名的数据，而不需要在代码中保存密钥：
+//
+//	kit := fixtures.New("1900009191")
+//	pay := wechatpay.New(kit.MchID, kit.APIv3Key, kit.MerchantPrivateKey, kit.MerchantCertificate)
+//	pay.AddPlatformCertificates(kit.PlatformCertificate)
+//	r, err := kit.NotificationRequest("/notify", model.EventTransactionSuccess, "transaction", transaction)
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+	"github.com/perlyna/wechatpay/util"
+)
+
+// Kit 一套测试用的商户及平台密钥
+type Kit struct {
+	MchID               string            // 商户号
+	APIv3Key            string            // APIv3密钥
+	MerchantPrivateKey  *rsa.PrivateKey   // 商户私钥
+	MerchantCertificate *x509.Certificate // 商户证书
+	MerchantSerialNo    string            // 商户证书序列号
+	PlatformPrivateKey  *rsa.PrivateKey   // 平台私钥，用于回包及回调通知签名
+	PlatformCertificate *x509.Certificate // 平台证书
+	PlatformSerialNo    string            // 平台证书序列号
+}
+
+// New 生成商户证书、平台证书及APIv3密钥，生成失败时 panic
+func New(mchID string) *Kit {
+	merchantKey, merchantCertificate := NewCertificate(mchID)
+	platformKey, platformCertificate := NewCertificate("Tenpay.com Root CA")
+	apiv3Key, err := core.GenerateNonce(32)
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: generate apiv3 key: %v", err))
+	}
+	return &Kit{
+		MchID:               mchID,
+		APIv3Key:            apiv3Key,
+		MerchantPrivateKey:  merchantKey,
+		MerchantCertificate: merchantCertificate,
+		MerchantSerialNo:    util.GetCertificateSerialNumber(merchantCertificate),
+		PlatformPrivateKey:  platformKey,
+		PlatformCertificate: platformCertificate,
+		PlatformSerialNo:    util.GetCertificateSerialNumber(platformCertificate),
+	}
+}
+
+// NewCertificate 生成RSA私钥及有效期5年的自签名证书，生成失败时 panic
+func NewCertificate(commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	return NewCertificateAt(commonName, time.Now().Add(-time.Hour))
+}
+
+// NewCertificateAt 生成从 notBefore 起有效期5年的自签名证书，用于测试证书轮换时按生效时间选择证书
+func NewCertificateAt(commonName string, notBefore time.Time) (*rsa.PrivateKey, *x509.Certificate) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: generate key: %v", err))
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: generate serial number: %v", err))
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(5 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: create certificate: %v", err))
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("fixtures: parse certificate: %v", err))
+	}
+	return privateKey, certificate
+}
+
+// Credential 使用商户私钥签名请求的 Authorization 生成器
+func (k *Kit) Credential() *core.WechatPayCredentials {
+	signer := &core.SHA256WithRSASigner{MchCertificateSerialNo: k.MerchantSerialNo, PrivateKey: k.MerchantPrivateKey}
+	return &core.WechatPayCredentials{Signer: signer, MchID: k.MchID}
+}
+
+// Validator 使用平台证书校验回包及回调通知签名的校验器
+func (k *Kit) Validator() *core.WechatPayValidator {
+	certificates := map[string]*x509.Certificate{k.PlatformSerialNo: k.PlatformCertificate}
+	return &core.WechatPayValidator{Verifier: &core.WechatPayVerifier{Certificates: certificates}}
+}
+
+// EncryptResource 使用APIv3密钥以 AEAD_AES_256_GCM 加密通知资源数据，plaintext 为 []byte、string 或需要序列化为json的结构
+func (k *Kit) EncryptResource(originalType string, plaintext interface{}) (model.NotificationResource, error) {
+	var data []byte
+	switch v := plaintext.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = json.Marshal(plaintext); err != nil {
+			return model.NotificationResource{}, err
+		}
+	}
+	nonce, err := core.GenerateNonce(12)
+	if err != nil {
+		return model.NotificationResource{}, err
+	}
+	ciphertext, err := util.EncryptToString(k.APIv3Key, originalType, nonce, string(data))
+	if err != nil {
+		return model.NotificationResource{}, err
+	}
+	return model.NotificationResource{
+		Algorithm:      util.AlgorithmAESGCM,
+		Ciphertext:     ciphertext,
+		OriginalType:   originalType,
+		AssociatedData: originalType,
+		Nonce:          nonce,
+	}, nil
+}
+
+// NotificationBody 生成回调通知内容，资源数据使用 EncryptResource 加密，通知ID为空时随机生成
+func (k *Kit) NotificationBody(id, eventType, originalType string, plaintext interface{}) ([]byte, error) {
+	resource, err := k.EncryptResource(originalType, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if id, err = core.GenerateNonce(32); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(model.Notification{
+		ID:           id,
+		CreateTime:   time.Now().Truncate(time.Second),
+		EventType:    eventType,
+		ResourceType: "encrypt-resource",
+		Summary:      "通知",
+		Resource:     resource,
+	})
+}
+
+// SignHeader 使用平台私钥以 at 为时间戳签名，设置 Request-Id 及 Wechatpay-* header，用于构造回包及回调通知
+func (k *Kit) SignHeader(header http.Header, body []byte, at time.Time) error {
+	nonce, err := core.GenerateNonce(core.NonceLength)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	signer := &core.SHA256WithRSASigner{MchCertificateSerialNo: k.PlatformSerialNo, PrivateKey: k.PlatformPrivateKey}
+	result, err := signer.Sign(context.Background(), fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body))
+	if err != nil {
+		return err
+	}
+	header.Set(core.RequestID, "FIXTURE"+strings.ToUpper(nonce[:24]))
+	header.Set(core.WechatPaySerial, k.PlatformSerialNo)
+	header.Set(core.WechatPayTimestamp, timestamp)
+	header.Set(core.WechatPayNonce, nonce)
+	header.Set(core.WechatPaySignature, result.Signature)
+	return nil
+}
+
+// NotificationRequest 生成发往 target 的已签名回调通知请求，可以直接传给 NotifyMux、ParseComplaintNotify 等
+func (k *Kit) NotificationRequest(target, eventType, originalType string, plaintext interface{}) (*http.Request, error) {
+	return k.NotificationRequestAt(target, "", eventType, originalType, plaintext, time.Now())
+}
+
+// NotificationRequestAt 生成通知ID为 id、以 at 为时间戳签名的回调通知请求，用于测试去重及过期通知
+func (k *Kit) NotificationRequestAt(target, id, eventType, originalType string, plaintext interface{}, at time.Time) (*http.Request, error) {
+	body, err := k.NotificationBody(id, eventType, originalType, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set(core.ContentType, core.ApplicationJSON)
+	if err = k.SignHeader(r.Header, body, at); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,58 @@
+package fixtures_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/model"
+	"github.com/perlyna/wechatpay/util"
+)
+
+func TestKit(t *testing.T) {
+	kit := fixtures.New("1900009191")
+	ctx := context.Background()
+
+	resource, err := kit.EncryptResource("transaction", `{"out_trade_no":"T1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := util.DecryptToByte(kit.APIv3Key, resource.AssociatedData, resource.Nonce, resource.Ciphertext)
+	if err != nil || string(plaintext) != `{"out_trade_no":"T1"}` {
+		t.Errorf("DecryptToByte() = %s, %v", plaintext, err)
+	}
+
+	r, err := kit.NotificationRequest("/notify", model.EventComplaintCreate, "complaint",
+		map[string]string{"complaint_id": "200201820200101080076610000", "action_type": "CREATE_COMPLAINT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	validator := kit.Validator()
+	if err = validator.Validate(ctx, body, r.Header); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err = validator.Validate(ctx, append(body, ' '), r.Header); err == nil {
+		t.Errorf("Validate() tampered body should fail")
+	}
+	stale := r.Header.Clone()
+	if err = kit.SignHeader(stale, body, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err = validator.Validate(ctx, body, stale); err == nil {
+		t.Errorf("Validate() stale timestamp should fail")
+	}
+
+	pay := wechatpay.New(kit.MchID, kit.APIv3Key, kit.MerchantPrivateKey, kit.MerchantCertificate)
+	pay.AddPlatformCertificates(kit.PlatformCertificate)
+	r, _ = kit.NotificationRequest("/notify", model.EventComplaintCreate, "complaint",
+		map[string]string{"complaint_id": "200201820200101080076610000", "action_type": "CREATE_COMPLAINT"})
+	event, err := pay.ParseComplaintNotify(r)
+	if err != nil || event.ComplaintID != "200201820200101080076610000" || event.ActionType != "CREATE_COMPLAINT" {
+		t.Errorf("ParseComplaintNotify() = %+v, %v", event, err)
+	}
+
+}
@@ -0,0 +1,260 @@
+// Package forward 把验签、解密后的微信支付回调通知转发给内部服务
+//
+// 内部服务不需要持有APIv3密钥，只需要使用共享的 secret 校验转发请求的 HMAC-SHA256 签名：
+//
+//	forwarder, err := forward.New("/var/lib/wechatpay/forward", secret, "http://orders.internal/wechatpay/events")
+//	go forwarder.Run(ctx)
+//	mux := pay.NewNotifyMux()
+//	mux.Handle("TRANSACTION.", forwarder)
+//	mux.Handle("REFUND.", forwarder)
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+)
+
+// 转发请求的header
+const (
+	HeaderEventID   = "X-Wechatpay-Event-Id"          // 通知ID，内部服务可用于去重
+	HeaderTimestamp = "X-Wechatpay-Forward-Timestamp" // 转发时间戳，秒
+	HeaderSignature = "X-Wechatpay-Forward-Signature" // sha256=<hex(HMAC-SHA256(secret, timestamp + "\n" + body))>
+)
+
+// DefaultRetryPolicy 默认投递重试策略：最多10次，1s起按2倍退避，最长10分钟
+func DefaultRetryPolicy() *core.RetryPolicy {
+	return &core.RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+		AttemptTimeout: 10 * time.Second,
+	}
+}
+
+// Event 转发给内部服务的通知内容
+type Event struct {
+	ID         string          `json:"id"`          // 通知ID
+	CreateTime time.Time       `json:"create_time"` // 通知创建时间
+	EventType  string          `json:"event_type"`  // 通知类型，如 TRANSACTION.SUCCESS
+	Summary    string          `json:"summary"`     // 回调摘要
+	Resource   json.RawMessage `json:"resource"`    // 解密后的资源数据
+}
+
+// Forwarder 通知转发器，实现了 NotifyHandler，可以注册到 NotifyMux
+//
+// 通知先写入持久化队列再应答微信支付，由 Run 在后台投递，失败时按重试策略重试，超过最多尝试次数后写入死信文件
+type Forwarder struct {
+	Client *http.Client      // http client，为空时使用 http.DefaultClient
+	Retry  *core.RetryPolicy // 投递重试策略，为空时只尝试一次
+	Logger core.Logger       // 日志，为空时不记录
+
+	urls   []string
+	secret []byte
+	queue  *queue
+}
+
+// New 创建通知转发器，dir 为持久化队列目录，死信写入 dir/dead-letter.jsonl
+func New(dir string, secret []byte, urls ...string) (*Forwarder, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("forward secret is empty")
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("forward urls is empty")
+	}
+	q, err := openQueue(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Forwarder{Retry: DefaultRetryPolicy(), urls: urls, secret: secret, queue: q}, nil
+}
+
+// ServeNotify 把通知写入每个转发地址的投递队列
+func (f *Forwarder) ServeNotify(ctx context.Context, notification *model.Notification) error {
+	resource := json.RawMessage(notification.Plaintext)
+	if len(resource) == 0 { // 没有资源数据时转发 null，空的 RawMessage 无法序列化
+		resource = json.RawMessage("null")
+	}
+	body, err := json.Marshal(Event{
+		ID:         notification.ID,
+		CreateTime: notification.CreateTime,
+		EventType:  notification.EventType,
+		Summary:    notification.Summary,
+		Resource:   resource,
+	})
+	if err != nil {
+		return err
+	}
+	for _, url := range f.urls {
+		if err = f.queue.push(&delivery{EventID: notification.ID, URL: url, Body: body, NextAttempt: time.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run 投递队列中的通知，直到 ctx 取消
+//
+// 每个转发地址由独立的协程按顺序投递，一个地址不可用或响应慢时不影响其他地址
+func (f *Forwarder) Run(ctx context.Context) error {
+	urls := append([]string(nil), f.urls...)
+	for _, url := range f.queue.urls() { // 转发地址变更前入队的投递
+		if !containsURL(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			f.run(ctx, url)
+		}(url)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// run 投递转发地址 url 的通知，直到 ctx 取消
+func (f *Forwarder) run(ctx context.Context, url string) {
+	wake := f.queue.wake(url)
+	for {
+		for _, d := range f.queue.due(url, time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			f.deliver(ctx, d)
+		}
+		timer := time.NewTimer(f.queue.wait(url, time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// containsURL urls 中是否包含 url
+func containsURL(urls []string, url string) bool {
+	for _, u := range urls {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver 投递一次，失败时重新排队或写入死信
+func (f *Forwarder) deliver(ctx context.Context, d *delivery) {
+	err := f.post(ctx, d)
+	d.Attempts++
+	if err == nil {
+		f.log(ctx, core.LogInfo, "wechatpay notification forwarded", "id", d.EventID, "url", d.URL, "attempts", d.Attempts)
+		if qerr := f.queue.remove(d); qerr != nil {
+			f.log(ctx, core.LogError, "wechatpay forward queue remove failed", "id", d.EventID, "error", qerr)
+		}
+		return
+	}
+	d.LastError = err.Error()
+	if f.Retry == nil || d.Attempts >= f.Retry.MaxAttempts {
+		f.log(ctx, core.LogError, "wechatpay notification forward dead-lettered", "id", d.EventID, "url", d.URL,
+			"attempts", d.Attempts, "error", err)
+		if qerr := f.queue.deadLetter(d); qerr != nil {
+			f.log(ctx, core.LogError, "wechatpay forward dead-letter failed", "id", d.EventID, "error", qerr)
+		}
+		return
+	}
+	d.NextAttempt = time.Now().Add(f.Retry.Backoff(d.Attempts))
+	f.log(ctx, core.LogWarn, "wechatpay notification forward failed", "id", d.EventID, "url", d.URL,
+		"attempts", d.Attempts, "next_attempt", d.NextAttempt, "error", err)
+	if qerr := f.queue.update(d); qerr != nil {
+		f.log(ctx, core.LogError, "wechatpay forward queue update failed", "id", d.EventID, "error", qerr)
+	}
+}
+
+// post 发送一次转发请求
+func (f *Forwarder) post(ctx context.Context, d *delivery) error {
+	if f.Retry != nil && f.Retry.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Retry.AttemptTimeout)
+		defer cancel()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set(core.ContentType, core.ApplicationJSON)
+	request.Header.Set(HeaderEventID, d.EventID)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(f.secret, timestamp, d.Body))
+	hc := f.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	response, err := hc.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	slurp, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("status %d: %s", response.StatusCode, slurp)
+	}
+	return nil
+}
+
+func (f *Forwarder) log(ctx context.Context, level core.LogLevel, msg string, keyvals ...interface{}) {
+	if f.Logger != nil {
+		f.Logger.Log(ctx, level, msg, keyvals...)
+	}
+}
+
+// Sign 计算转发请求的签名
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(timestamp + "\n"))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 内部服务校验转发请求的签名，maxAge 为允许的最大时间差，为0时不检查
+func Verify(secret []byte, header http.Header, body []byte, maxAge time.Duration) error {
+	timestamp := strings.TrimSpace(header.Get(HeaderTimestamp))
+	signature := strings.TrimSpace(header.Get(HeaderSignature))
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("empty %s or %s", HeaderTimestamp, HeaderSignature)
+	}
+	if maxAge > 0 {
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp:[%s] err:[%v]", timestamp, err)
+		}
+		if math.Abs(float64(time.Now().Unix()-unix)) > maxAge.Seconds() {
+			return fmt.Errorf("timestamp=[%s] expires", timestamp)
+		}
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("forward signature mismatch")
+	}
+	return nil
+}
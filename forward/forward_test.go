@@ -0,0 +1,127 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+)
+
+func TestForwarder(t *testing.T) {
+	secret := []byte("internal-secret")
+	var mu sync.Mutex
+	var received []Event
+	var calls int
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := Verify(secret, r.Header, body, time.Minute); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		_ = json.Unmarshal(body, &event)
+		received = append(received, event)
+	}))
+	defer orders.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	dir := t.TempDir()
+	forwarder, err := New(dir, secret, orders.URL, broken.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder.Retry = &core.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	err = forwarder.ServeNotify(context.Background(), &model.Notification{ID: "EV-1",
+		EventType: model.EventTransactionSuccess, Plaintext: []byte(`{"out_trade_no":"T1"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() { _ = forwarder.Run(ctx) }()
+
+	deadLetterPath := filepath.Join(dir, deadLetterFile)
+	for ctx.Err() == nil {
+		mu.Lock()
+		done := len(received) == 1
+		mu.Unlock()
+		deadLetter, _ := ioutil.ReadFile(deadLetterPath)
+		if done && len(deadLetter) > 0 {
+			if !strings.Contains(string(deadLetter), broken.URL) {
+				t.Errorf("dead letter = %s", deadLetter)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("timeout, received = %v", received)
+	}
+	if received[0].ID != "EV-1" || string(received[0].Resource) != `{"out_trade_no":"T1"}` {
+		t.Errorf("received = %+v", received[0])
+	}
+	if err = Verify([]byte("other"), http.Header{HeaderTimestamp: {"1"}, HeaderSignature: {Sign(secret, "1", nil)}}, nil, 0); err == nil {
+		t.Errorf("Verify() with other secret should fail")
+	}
+}
+
+func TestForwarderSlowURL(t *testing.T) {
+	secret := []byte("internal-secret")
+	received := make(chan Event, 1)
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer orders.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body) // 读完请求体后才能感知客户端断开
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	forwarder, err := New(t.TempDir(), secret, slow.URL, orders.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder.Retry = nil
+	// 没有资源数据的通知转发 null
+	if err = forwarder.ServeNotify(context.Background(), &model.Notification{ID: "EV-2", EventType: "PROFITSHARING.RETURN"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- forwarder.Run(ctx) }()
+
+	// 慢的转发地址不影响其他地址的投递
+	select {
+	case event := <-received:
+		if event.ID != "EV-2" || string(event.Resource) != "null" {
+			t.Errorf("received = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("delivery to %s blocked by slow url", orders.URL)
+	}
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Run() = %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package forward
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 队列目录中的文件
+const (
+	pendingDir     = "pending"           // 待投递，每个投递一个文件
+	deadLetterFile = "dead-letter.jsonl" // 死信，超过最多尝试次数的投递
+)
+
+// pollInterval 没有待投递的通知时，检查队列的间隔
+const pollInterval = time.Minute
+
+// delivery 一个通知到一个转发地址的投递
+type delivery struct {
+	EventID     string          `json:"event_id"`             // 通知ID
+	URL         string          `json:"url"`                  // 转发地址
+	Body        json.RawMessage `json:"body"`                 // 转发内容
+	Attempts    int             `json:"attempts"`             // 已尝试次数
+	NextAttempt time.Time       `json:"next_attempt"`         // 下次尝试时间
+	LastError   string          `json:"last_error,omitempty"` // 最近一次失败原因
+}
+
+// name 投递的文件名，同一通知重复入队时覆盖
+func (d *delivery) name() string {
+	sum := sha256.Sum256([]byte(d.EventID + "\n" + d.URL))
+	return hex.EncodeToString(sum[:16]) + ".json"
+}
+
+// queue 以目录持久化的投递队列，进程重启后继续投递
+type queue struct {
+	dir string
+
+	mu      sync.Mutex
+	pending map[string]*delivery     // 文件名 -> 投递
+	wakes   map[string]chan struct{} // 转发地址 -> 有新投递时唤醒该地址的投递协程
+}
+
+// openQueue 打开队列目录，加载未完成的投递
+func openQueue(dir string) (*queue, error) {
+	if err := os.MkdirAll(filepath.Join(dir, pendingDir), 0700); err != nil {
+		return nil, err
+	}
+	q := &queue{dir: dir, pending: make(map[string]*delivery), wakes: make(map[string]chan struct{})}
+	files, err := ioutil.ReadDir(filepath.Join(dir, pendingDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, pendingDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		d := &delivery{}
+		if err = json.Unmarshal(data, d); err != nil {
+			return nil, err
+		}
+		q.pending[file.Name()] = d
+	}
+	return q, nil
+}
+
+// write 先写临时文件再重命名，避免进程退出时留下不完整的文件
+func (q *queue) write(d *delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.dir, pendingDir, d.name())
+	if err = ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// push 投递入队，写入文件后返回
+func (q *queue) push(d *delivery) error {
+	if err := q.write(d); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.pending[d.name()] = d
+	q.mu.Unlock()
+	select {
+	case q.wake(d.URL) <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// wake 转发地址的唤醒通道
+func (q *queue) wake(url string) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wake, ok := q.wakes[url]
+	if !ok {
+		wake = make(chan struct{}, 1)
+		q.wakes[url] = wake
+	}
+	return wake
+}
+
+// urls 队列中待投递的转发地址
+func (q *queue) urls() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var urls []string
+	seen := make(map[string]bool)
+	for _, d := range q.pending {
+		if !seen[d.URL] {
+			seen[d.URL] = true
+			urls = append(urls, d.URL)
+		}
+	}
+	return urls
+}
+
+// update 保存投递失败后的状态
+func (q *queue) update(d *delivery) error {
+	if err := q.write(d); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.pending[d.name()] = d
+	q.mu.Unlock()
+	return nil
+}
+
+// remove 投递成功，移出队列
+func (q *queue) remove(d *delivery) error {
+	q.mu.Lock()
+	delete(q.pending, d.name())
+	q.mu.Unlock()
+	return os.Remove(filepath.Join(q.dir, pendingDir, d.name()))
+}
+
+// deadLetter 追加到死信文件并移出队列
+func (q *queue) deadLetter(d *delivery) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(q.dir, deadLetterFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return q.remove(d)
+}
+
+// due 转发地址 url 到达尝试时间的投递副本，按尝试时间排序
+func (q *queue) due(url string, now time.Time) []*delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var deliveries []*delivery
+	for _, d := range q.pending {
+		if d.URL == url && !d.NextAttempt.After(now) {
+			c := *d
+			deliveries = append(deliveries, &c)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt.Before(deliveries[j].NextAttempt)
+	})
+	return deliveries
+}
+
+// wait 距离转发地址 url 下一个投递的等待时间
+func (q *queue) wait(url string, now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wait := pollInterval
+	for _, d := range q.pending {
+		if d.URL != url {
+			continue
+		}
+		if next := d.NextAttempt.Sub(now); next < wait {
+			wait = next
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
@@ -1,3 +1,8 @@
 module github.com/perlyna/wechatpay
 
 go 1.15
+
+require (
+	github.com/tjfoc/gmsm v1.4.1
+	golang.org/x/crypto v0.0.0-20201012173705-84dcc777aaee
+)
@@ -23,17 +23,17 @@ type ComplaintOrderInfo struct {
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_13.shtml
 // 更新时间: 2021.04.01
 type Complaint struct {
-	ComplaintID           string               `json:"complaint_id"`            // 投诉单号
-	ComplaintTime         time.Time            `json:"complaint_time"`          // 投诉时间
-	ComplaintDetail       string               `json:"complaint_detail"`        // 投诉详情
-	ComplaintedMchID      string               `json:"complainted_mchid"`       // 投诉商户号
-	ComplaintState        string               `json:"complaint_state"`         // 投诉单状态;PENDING：待处理;PROCESSING：处理中;PROCESSED：已处理完成
-	PayerPhone            string               `json:"payer_phone"`             // 投诉人联系方式
-	PayerOpenID           string               `json:"payer_openid"`            // 投诉人openid
-	Order                 []ComplaintOrderInfo `json:"complaint_order_info"`    // 投诉单关联订单信息
-	ComplaintFullRefunded bool                 `json:"complaint_full_refunded"` // 投诉单是否已全额退款
-	IncomingUserResponse  bool                 `json:"incoming_user_response"`  // 是否有待回复的用户留言
-	UserComplaintTimes    int                  `json:"user_complaint_times"`    // 用户投诉次数。用户首次发起投诉记为1次，用户每有一次继续投诉就加1
+	ComplaintID           string               `json:"complaint_id"`                    // 投诉单号
+	ComplaintTime         time.Time            `json:"complaint_time"`                  // 投诉时间
+	ComplaintDetail       string               `json:"complaint_detail"`                // 投诉详情
+	ComplaintedMchID      string               `json:"complainted_mchid"`               // 投诉商户号
+	ComplaintState        string               `json:"complaint_state"`                 // 投诉单状态;PENDING：待处理;PROCESSING：处理中;PROCESSED：已处理完成
+	PayerPhone            string               `json:"payer_phone" wechatpay:"encrypt"` // 投诉人联系方式
+	PayerOpenID           string               `json:"payer_openid"`                    // 投诉人openid
+	Order                 []ComplaintOrderInfo `json:"complaint_order_info"`            // 投诉单关联订单信息
+	ComplaintFullRefunded bool                 `json:"complaint_full_refunded"`         // 投诉单是否已全额退款
+	IncomingUserResponse  bool                 `json:"incoming_user_response"`          // 是否有待回复的用户留言
+	UserComplaintTimes    int                  `json:"user_complaint_times"`            // 用户投诉次数。用户首次发起投诉记为1次，用户每有一次继续投诉就加1
 }
 
 // ComplaintEvent 投诉通知回调事件请求参数
@@ -46,7 +46,7 @@ type ComplaintEvent struct {
 	ResourceType string    `json:"resource_type"` // 通知的资源数据类型，支付成功通知为encrypt-resource
 	Summary      string    `json:"summary"`       // 回调摘要
 	Resource     struct {  // 通知资源数据
-		Algorithm      string `json:"algorithm"`       // 加密算法类型,目前只支持AEAD_AES_256_GCM
+		Algorithm      string `json:"algorithm"`       // 加密算法类型,AEAD_AES_256_GCM 或国密 AEAD_SM4_GCM
 		Ciphertext     string `json:"ciphertext"`      // Base64编码后的开启/停用结果数据密文
 		OriginalType   string `json:"original_type"`   // Base64编码后的开启/停用结果数据密文
 		AssociatedData string `json:"associated_data"` // 附加数据
@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+// 回调通知类型
+const (
+	EventTransactionSuccess   = "TRANSACTION.SUCCESS"    // 支付成功
+	EventRefundSuccess        = "REFUND.SUCCESS"         // 退款成功
+	EventRefundAbnormal       = "REFUND.ABNORMAL"        // 退款异常
+	EventRefundClosed         = "REFUND.CLOSED"          // 退款关闭
+	EventComplaintCreate      = "COMPLAINT.CREATE"       // 产生新投诉
+	EventComplaintStateChange = "COMPLAINT.STATE_CHANGE" // 投诉状态变化
+)
+
+// NotificationResource 回调通知中的加密资源数据
+type NotificationResource struct {
+	Algorithm      string `json:"algorithm"`       // 加密算法类型,AEAD_AES_256_GCM 或国密 AEAD_SM4_GCM
+	Ciphertext     string `json:"ciphertext"`      // Base64编码后的数据密文
+	OriginalType   string `json:"original_type"`   // 原始回调类型
+	AssociatedData string `json:"associated_data"` // 附加数据
+	Nonce          string `json:"nonce"`           // 加密使用的随机串
+}
+
+// Notification 回调通知
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay4_1.shtml
+type Notification struct {
+	ID           string               `json:"id"`            // 通知ID
+	CreateTime   time.Time            `json:"create_time"`   // 通知创建时间
+	EventType    string               `json:"event_type"`    // 通知类型，如 TRANSACTION.SUCCESS
+	ResourceType string               `json:"resource_type"` // 通知的资源数据类型，一般为encrypt-resource
+	Summary      string               `json:"summary"`       // 回调摘要
+	Resource     NotificationResource `json:"resource"`      // 通知资源数据
+
+	Plaintext []byte `json:"-"` // 通知资源数据解密后的明文
+}
+
+// RefundNotify 退款结果通知资源数据
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_11.shtml
+type RefundNotify struct {
+	MchID               string     `json:"mchid"`                 // 商户号
+	OutTradeNo          string     `json:"out_trade_no"`          // 商户订单号
+	TransactionID       string     `json:"transaction_id"`        // 微信支付订单号
+	OutRefundNo         string     `json:"out_refund_no"`         // 商户退款单号
+	RefundID            string     `json:"refund_id"`             // 微信支付退款号
+	RefundStatus        string     `json:"refund_status"`         // 退款状态; SUCCESS：退款成功; CLOSED：退款关闭; ABNORMAL：退款异常
+	SuccessTime         *time.Time `json:"success_time"`          // 退款成功时间
+	UserReceivedAccount string     `json:"user_received_account"` // 退款入账账户
+	Amount              struct {   // 金额信息
+		Total       int `json:"total"`        // 订单金额
+		Refund      int `json:"refund"`       // 退款金额
+		PayerTotal  int `json:"payer_total"`  // 用户支付金额
+		PayerRefund int `json:"payer_refund"` // 用户退款金额
+	} `json:"amount"`
+}
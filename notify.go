@@ -0,0 +1,402 @@
+package wechatpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+)
+
+// DefaultNotifyMaxBodyBytes 回调通知请求体默认的最大字节数
+const DefaultNotifyMaxBodyBytes = 1 << 20
+
+// errUnhandledEventType 没有对应处理器的通知类型
+var errUnhandledEventType = errors.New("no handler for event_type")
+
+// NotifyHandler 回调通知处理器，返回错误时应答失败，微信支付会重新发送通知
+type NotifyHandler interface {
+	ServeNotify(ctx context.Context, notification *model.Notification) error
+}
+
+// NotifyHandlerFunc 函数形式的回调通知处理器
+type NotifyHandlerFunc func(ctx context.Context, notification *model.Notification) error
+
+// ServeNotify 处理回调通知
+func (f NotifyHandlerFunc) ServeNotify(ctx context.Context, notification *model.Notification) error {
+	return f(ctx, notification)
+}
+
+// NotifyMux 回调通知路由，校验签名、解密资源数据后按 event_type 分发给注册的处理器
+//
+// 支付、退款、投诉、分账等回调通知可以使用同一个地址，处理成功时应答 SUCCESS，
+// 验签失败、解密失败或处理器返回错误时应答失败，微信支付会重新发送通知；
+// 没有对应处理器的通知类型记录 WARN 日志后默认应答 SUCCESS，避免微信支付在整个重试周期内重复发送；
+// 设置 Store 后每个通知只会分发一次，重复的通知直接应答 SUCCESS；
+// 设置 Archive 后收到的原始请求会先写入存档，问题修复后可以通过 Replay 或 wechatpay-notify replay 回放
+type NotifyMux struct {
+	Store           core.NotificationStore   // 通知去重存储，为空时不去重
+	Archive         core.NotificationArchive // 通知存档，为空时不存档
+	UnhandledStatus int                      // 没有对应处理器时应答的http状态码，为0时应答200 SUCCESS，2xx时应答 SUCCESS，非2xx时微信支付会重新发送
+	MaxBodyBytes    int64                    // 通知请求体的最大字节数，为0时为 DefaultNotifyMaxBodyBytes
+
+	pay      *WechatPay
+	mu       sync.RWMutex
+	handlers map[string]NotifyHandler
+}
+
+// NewNotifyMux 创建回调通知路由
+func (p *WechatPay) NewNotifyMux() *NotifyMux {
+	return &NotifyMux{pay: p, handlers: make(map[string]NotifyHandler)}
+}
+
+// Handle 注册 eventType 的处理器
+//
+// eventType 以 . 结尾时匹配该前缀的所有通知类型，如 REFUND. 匹配 REFUND.SUCCESS、REFUND.ABNORMAL 等，
+// 完全匹配优先，其次是最长的前缀
+func (m *NotifyMux) Handle(eventType string, handler NotifyHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[eventType] = handler
+}
+
+// HandleFunc 注册 eventType 的处理函数
+func (m *NotifyMux) HandleFunc(eventType string, handler func(ctx context.Context, notification *model.Notification) error) {
+	m.Handle(eventType, NotifyHandlerFunc(handler))
+}
+
+// HandleTransaction 注册支付通知的处理函数，资源数据解析为 model.TradeQuery
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_5.shtml
+func (m *NotifyMux) HandleTransaction(eventType string,
+	handler func(ctx context.Context, notification *model.Notification, transaction *model.TradeQuery) error) {
+	m.HandleFunc(eventType, func(ctx context.Context, notification *model.Notification) error {
+		transaction := &model.TradeQuery{}
+		if err := json.Unmarshal(notification.Plaintext, transaction); err != nil {
+			return fmt.Errorf("解析支付通知失败 %w", err)
+		}
+		return handler(ctx, notification, transaction)
+	})
+}
+
+// HandleRefund 注册退款通知的处理函数，资源数据解析为 model.RefundNotify
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_11.shtml
+func (m *NotifyMux) HandleRefund(eventType string,
+	handler func(ctx context.Context, notification *model.Notification, refund *model.RefundNotify) error) {
+	m.HandleFunc(eventType, func(ctx context.Context, notification *model.Notification) error {
+		refund := &model.RefundNotify{}
+		if err := json.Unmarshal(notification.Plaintext, refund); err != nil {
+			return fmt.Errorf("解析退款通知失败 %w", err)
+		}
+		return handler(ctx, notification, refund)
+	})
+}
+
+// HandleComplaint 注册投诉通知的处理函数
+// 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter10_2_16.shtml
+func (m *NotifyMux) HandleComplaint(eventType string, handler func(ctx context.Context, event *model.ComplaintEvent) error) {
+	m.HandleFunc(eventType, func(ctx context.Context, notification *model.Notification) error {
+		event := &model.ComplaintEvent{
+			ID:           notification.ID,
+			CreateTime:   notification.CreateTime,
+			EventType:    notification.EventType,
+			ResourceType: notification.ResourceType,
+			Summary:      notification.Summary,
+			Resource:     notification.Resource,
+		}
+		if err := json.Unmarshal(notification.Plaintext, event); err != nil {
+			return fmt.Errorf("解析投诉通知失败 %w", err)
+		}
+		return handler(ctx, event)
+	})
+}
+
+// handler 获取 eventType 对应的处理器
+func (m *NotifyMux) handler(eventType string) NotifyHandler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if handler, ok := m.handlers[eventType]; ok {
+		return handler
+	}
+	var matched string
+	var handler NotifyHandler
+	for pattern, h := range m.handlers {
+		if strings.HasSuffix(pattern, ".") && strings.HasPrefix(eventType, pattern) && len(pattern) > len(matched) {
+			matched, handler = pattern, h
+		}
+	}
+	return handler
+}
+
+// ServeHTTP 处理微信支付的回调通知请求
+func (m *NotifyMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := m.pay.startNotifySpan(r)
+	maxBodyBytes := m.maxBodyBytes()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		status := http.StatusBadRequest
+		if int64(len(body)) >= maxBodyBytes {
+			status = http.StatusRequestEntityTooLarge
+		}
+		err = &notifyError{status: status, message: "读取请求内容失败", err: err}
+		core.EndSpan(span, err)
+		writeNotifyAck(w, err)
+		return
+	}
+	if m.Archive != nil {
+		if aerr := m.Archive.Append(ctx, core.NewArchivedNotification(r.Header, body)); aerr != nil {
+			m.pay.log(ctx, core.LogError, "wechatpay notification archive failed", "error", aerr)
+		}
+	}
+	notification, err := m.serve(ctx, r.Header, body, false)
+	if notification != nil {
+		span.SetAttribute(core.AttrEventID, notification.ID)
+		span.SetAttribute(core.AttrEventType, notification.EventType)
+	}
+	core.EndSpan(span, err)
+	if err == nil && notification != nil && m.UnhandledStatus != 0 && m.handler(notification.EventType) == nil {
+		// 未处理的通知类型使用配置的2xx状态码应答 SUCCESS
+		err = &notifyError{status: m.UnhandledStatus, message: "未处理的通知类型"}
+	}
+	writeNotifyAck(w, err)
+}
+
+// Replay 回放存档的历史通知
+//
+// 回放时跳过时间戳检查，但仍然使用平台证书校验签名，已过期的平台证书可以通过 AddPlatformCertificates 添加；
+// 回放不经过 Store 去重，通知会重新分发给处理器
+func (m *NotifyMux) Replay(ctx context.Context, notification *core.ArchivedNotification) error {
+	ctx, span := core.StartSpan(core.WithReplay(ctx), m.pay.Tracer, core.SpanNotification)
+	span.SetAttribute(core.AttrMchID, m.pay.mchID)
+	n, err := m.serve(ctx, notification.Header, []byte(notification.Body), true)
+	if n != nil {
+		span.SetAttribute(core.AttrEventID, n.ID)
+		span.SetAttribute(core.AttrEventType, n.EventType)
+	}
+	core.EndSpan(span, err)
+	return err
+}
+
+// ReplayHandler 接收 wechatpay-notify replay 发送的存档记录并回放，应答格式与回调通知相同
+//
+// 回放跳过时间戳检查及 Store 去重，因此回放请求必须使用共享的 secret 签名（见 core.SignReplayRequest），
+// 请求时间戳需在5分钟内且同一签名只能使用一次；secret 为空时返回错误。只应挂载在内网的管理地址上
+func (m *NotifyMux) ReplayHandler(secret []byte) (http.Handler, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("replay secret is empty")
+	}
+	secret = append([]byte(nil), secret...)
+	nonces := core.NewMemoryNonceCache()
+	maxAge := core.FiveMinute * time.Second
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, m.maxBodyBytes()))
+		if err != nil {
+			writeNotifyAck(w, &notifyError{status: http.StatusBadRequest, message: "读取请求内容失败", err: err})
+			return
+		}
+		now := time.Now()
+		err = core.VerifyReplayRequest(r.Header, secret, body, now, maxAge)
+		if err == nil {
+			err = nonces.Use(r.Context(), r.Header.Get(core.HeaderReplaySignature), now, now.Add(2*maxAge))
+		}
+		if err != nil {
+			m.pay.log(r.Context(), core.LogWarn, "wechatpay notification replay rejected", "error", err)
+			writeNotifyAck(w, &notifyError{status: http.StatusUnauthorized, message: "回放请求签名错误", err: err})
+			return
+		}
+		notification := &core.ArchivedNotification{}
+		if err = json.Unmarshal(body, notification); err != nil {
+			writeNotifyAck(w, &notifyError{status: http.StatusBadRequest, message: "存档记录格式错误", err: err})
+			return
+		}
+		writeNotifyAck(w, m.Replay(r.Context(), notification))
+	}), nil
+}
+
+// maxBodyBytes 通知请求体的最大字节数
+func (m *NotifyMux) maxBodyBytes() int64 {
+	if m.MaxBodyBytes <= 0 {
+		return DefaultNotifyMaxBodyBytes
+	}
+	return m.MaxBodyBytes
+}
+
+// serve 校验签名、解密资源数据并分发给处理器，replay 为 true 时不经过 Store 去重
+func (m *NotifyMux) serve(ctx context.Context, header http.Header, body []byte, replay bool) (*model.Notification, error) {
+	p := m.pay
+	validateCtx := ctx
+	var diagnostics *core.SignatureDiagnostics
+	if p.DebugSignature {
+		diagnostics = &core.SignatureDiagnostics{Method: http.MethodPost}
+		validateCtx = core.WithSignatureDiagnostics(ctx, diagnostics)
+	}
+	if err := p.currentValidator().Validate(validateCtx, body, header); err != nil {
+		if diagnostics != nil {
+			err = &core.SignatureError{Diagnostics: diagnostics, Err: err}
+		}
+		p.log(ctx, core.LogWarn, "wechatpay notification signature validation failed",
+			"request_id", header.Get(core.RequestID), "serial", header.Get(core.WechatPaySerial), "error", err)
+		if p.Metrics != nil {
+			p.Metrics.SignatureFailure(ctx, core.SignatureSourceNotification)
+		}
+		return nil, &notifyError{status: http.StatusUnauthorized, message: "签名错误", err: err}
+	}
+	notification := &model.Notification{}
+	if err := json.Unmarshal(body, notification); err != nil {
+		return nil, m.fail(ctx, notification, &notifyError{status: http.StatusBadRequest, message: "通知格式错误", err: err})
+	}
+	nonce := header.Get(core.WechatPayNonce)
+	store := m.Store
+	if replay {
+		store = nil
+	}
+	if store != nil {
+		switch err := store.Reserve(ctx, notification.ID, nonce); {
+		case errors.Is(err, core.ErrNotificationDuplicate):
+			p.log(ctx, core.LogInfo, "wechatpay notification duplicate", "id", notification.ID,
+				"event_type", notification.EventType)
+			if p.Metrics != nil {
+				p.Metrics.NotificationProcessed(ctx, notification.EventType, core.NotifyDuplicate)
+			}
+			return notification, nil
+		case errors.Is(err, core.ErrNotificationInProgress):
+			return notification, &notifyError{status: http.StatusConflict, message: "通知正在处理", err: err}
+		case err != nil:
+			return notification, m.fail(ctx, notification, &notifyError{status: http.StatusInternalServerError, message: "处理失败", err: err})
+		}
+	}
+	committed := false
+	if store != nil {
+		// 处理器返回错误或 panic 时都释放预占，以便微信支付重试时重新处理
+		defer func() {
+			if committed {
+				return
+			}
+			if rerr := store.Release(ctx, notification.ID, nonce); rerr != nil {
+				p.log(ctx, core.LogError, "wechatpay notification release failed", "id", notification.ID, "error", rerr)
+			}
+		}()
+	}
+	commit := func() {
+		committed = true
+		if store == nil {
+			return
+		}
+		if err := store.Commit(ctx, notification.ID, nonce); err != nil {
+			p.log(ctx, core.LogError, "wechatpay notification commit failed", "id", notification.ID, "error", err)
+		}
+	}
+	err := m.dispatch(ctx, notification)
+	if err != nil && acknowledged(err.status) && errors.Is(err, errUnhandledEventType) { // 未处理的通知类型按成功应答，不再重试
+		p.log(ctx, core.LogWarn, "wechatpay notification unhandled", "id", notification.ID,
+			"event_type", notification.EventType, "replay", replay)
+		commit()
+		if p.Metrics != nil {
+			p.Metrics.NotificationProcessed(ctx, notification.EventType, core.NotifyUnhandled)
+		}
+		return notification, nil
+	}
+	if err != nil {
+		return notification, m.fail(ctx, notification, err)
+	}
+	commit()
+	if p.Metrics != nil {
+		p.Metrics.NotificationProcessed(ctx, notification.EventType, core.NotifySuccess)
+	}
+	p.log(ctx, core.LogInfo, "wechatpay notification processed", "id", notification.ID,
+		"event_type", notification.EventType, "replay", replay)
+	return notification, nil
+}
+
+// dispatch 解密资源数据并分发给处理器，处理器 panic 时返回处理失败
+func (m *NotifyMux) dispatch(ctx context.Context, notification *model.Notification) (nerr *notifyError) {
+	plaintext, err := m.pay.decryptResource(ctx, notification.Resource.Algorithm, notification.Resource.AssociatedData,
+		notification.Resource.Nonce, notification.Resource.Ciphertext)
+	if err != nil {
+		return &notifyError{status: http.StatusInternalServerError, message: "解密失败", err: err}
+	}
+	notification.Plaintext = plaintext
+	handler := m.handler(notification.EventType)
+	if handler == nil {
+		status := m.UnhandledStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return &notifyError{status: status, message: "未处理的通知类型",
+			err: fmt.Errorf("%w %s", errUnhandledEventType, notification.EventType)}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			m.pay.log(ctx, core.LogError, "wechatpay notification handler panic", "id", notification.ID,
+				"event_type", notification.EventType, "panic", r, "stack", string(debug.Stack()))
+			nerr = &notifyError{status: http.StatusInternalServerError, message: "处理失败", err: fmt.Errorf("handler panic: %v", r)}
+		}
+	}()
+	if err = handler.ServeNotify(ctx, notification); err != nil {
+		return &notifyError{status: http.StatusInternalServerError, message: "处理失败", err: err}
+	}
+	return nil
+}
+
+// fail 记录处理失败的通知
+func (m *NotifyMux) fail(ctx context.Context, notification *model.Notification, err *notifyError) error {
+	p := m.pay
+	p.log(ctx, core.LogError, "wechatpay notification failed", "id", notification.ID,
+		"event_type", notification.EventType, "error", err)
+	if p.Metrics != nil {
+		p.Metrics.NotificationProcessed(ctx, notification.EventType, core.NotifyFailure)
+	}
+	return err
+}
+
+// notifyError 回调通知处理错误，status 为应答的http状态码
+type notifyError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *notifyError) Error() string {
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
+func (e *notifyError) Unwrap() error {
+	return e.err
+}
+
+// notifyAck 回调通知应答
+type notifyAck struct {
+	Code    string `json:"code"`    // SUCCESS 或 FAIL
+	Message string `json:"message"` // 返回信息
+}
+
+// acknowledged 应答的http状态码是否为2xx，微信支付收到2xx应答后不再重新发送通知
+func acknowledged(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// writeNotifyAck 应答回调通知，err 为空或状态码为2xx时应答 SUCCESS
+func writeNotifyAck(w http.ResponseWriter, err error) {
+	status, ack := http.StatusOK, notifyAck{Code: "SUCCESS", Message: "成功"}
+	if err != nil {
+		status, ack = http.StatusInternalServerError, notifyAck{Code: "FAIL", Message: "失败"}
+		var nerr *notifyError
+		if errors.As(err, &nerr) {
+			status, ack.Message = nerr.status, nerr.message
+			if acknowledged(status) {
+				ack.Code = "SUCCESS"
+			}
+		}
+	}
+	body, _ := json.Marshal(ack)
+	w.Header().Set(core.ContentType, core.ApplicationJSON)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,235 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/model"
+)
+
+func TestNotifyMux(t *testing.T) {
+	p, kit := newTestWechatPay(t)
+	mux := p.NewNotifyMux()
+	var transaction *model.TradeQuery
+	mux.HandleTransaction(model.EventTransactionSuccess,
+		func(ctx context.Context, n *model.Notification, tx *model.TradeQuery) error {
+			transaction = tx
+			return nil
+		})
+	var refunds []string
+	mux.HandleRefund("REFUND.", func(ctx context.Context, n *model.Notification, refund *model.RefundNotify) error {
+		refunds = append(refunds, n.EventType)
+		if refund.RefundStatus == "ABNORMAL" {
+			return errors.New("bank account closed")
+		}
+		return nil
+	})
+	var complaint *model.ComplaintEvent
+	mux.HandleComplaint(model.EventComplaintStateChange, func(ctx context.Context, event *model.ComplaintEvent) error {
+		complaint = event
+		return nil
+	})
+
+	tests := []struct {
+		eventType string
+		plaintext string
+		status    int
+	}{
+		{model.EventTransactionSuccess, `{"out_trade_no":"1217752501201407033233368018","trade_state":"SUCCESS"}`, http.StatusOK},
+		{model.EventRefundSuccess, `{"out_refund_no":"R1","refund_status":"SUCCESS"}`, http.StatusOK},
+		{model.EventRefundAbnormal, `{"out_refund_no":"R2","refund_status":"ABNORMAL"}`, http.StatusInternalServerError},
+		{model.EventComplaintStateChange, `{"complaint_id":"200201820200101080076610000","action_type":"CREATE_COMPLAINT"}`, http.StatusOK},
+		{"PROFITSHARING.RETURN", `{}`, http.StatusOK},
+	}
+	for i, tt := range tests {
+		r, _ := kit.NotificationRequestAt("/notify", fmt.Sprintf("EV-%d", i), tt.eventType, "transaction", tt.plaintext, time.Now())
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s status = %d, want %d, body %s", tt.eventType, w.Code, tt.status, w.Body.String())
+		}
+	}
+	if transaction == nil || transaction.OutTradeNo != "1217752501201407033233368018" {
+		t.Errorf("transaction = %+v", transaction)
+	}
+	if len(refunds) != 2 || refunds[1] != model.EventRefundAbnormal {
+		t.Errorf("refunds = %v", refunds)
+	}
+	if complaint == nil || complaint.ID != "EV-3" || complaint.ComplaintID != "200201820200101080076610000" {
+		t.Errorf("complaint = %+v", complaint)
+	}
+
+	// 签名错误的通知不会分发给处理器
+	forged := *kit
+	forged.PlatformPrivateKey, _ = fixtures.NewCertificate("Tenpay.com Root CA")
+	transaction = nil
+	r, _ := forged.NotificationRequestAt("/notify", "EV-FORGED", model.EventTransactionSuccess, "transaction", `{"trade_state":"SUCCESS"}`, time.Now())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || transaction != nil {
+		t.Errorf("forged notification status = %d, transaction = %+v", w.Code, transaction)
+	}
+	var ack map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &ack); err != nil || ack["code"] != "FAIL" {
+		t.Errorf("ack = %s", w.Body.String())
+	}
+
+	// 其他2xx状态码同样按成功应答
+	mux.UnhandledStatus = http.StatusAccepted
+	r, _ = kit.NotificationRequestAt("/notify", "EV-ACCEPTED", "PROFITSHARING.RETURN", "transaction", `{}`, time.Now())
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if err := json.Unmarshal(w.Body.Bytes(), &ack); w.Code != http.StatusAccepted || err != nil || ack["code"] != "SUCCESS" {
+		t.Errorf("accepted unhandled status = %d, ack = %s", w.Code, w.Body.String())
+	}
+
+	// 未处理的通知类型可以配置为应答失败，让微信支付重新发送
+	mux.UnhandledStatus = http.StatusNotFound
+	r, _ = kit.NotificationRequestAt("/notify", "EV-UNHANDLED", "PROFITSHARING.RETURN", "transaction", `{}`, time.Now())
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unhandled status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// 超过 MaxBodyBytes 的请求不会读取完整内容
+	mux.MaxBodyBytes = 64
+	r, _ = kit.NotificationRequestAt("/notify", "EV-LARGE", model.EventTransactionSuccess, "transaction", `{"trade_state":"SUCCESS"}`, time.Now())
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large notification status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestNotifyMuxDeduplicates(t *testing.T) {
+	p, kit := newTestWechatPay(t)
+	mux := p.NewNotifyMux()
+	mux.Store = core.NewMemoryNotificationStore(time.Hour)
+	var calls int
+	mux.HandleFunc(model.EventTransactionSuccess, func(ctx context.Context, n *model.Notification) error {
+		calls++
+		switch calls {
+		case 1:
+			return errors.New("database unavailable")
+		case 2:
+			panic("nil order")
+		}
+		return nil
+	})
+	statuses := make([]int, 0, 4)
+	for i := 0; i < 4; i++ {
+		r, _ := kit.NotificationRequestAt("/notify", "EV-1", model.EventTransactionSuccess, "transaction", `{"trade_state":"SUCCESS"}`, time.Now())
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		statuses = append(statuses, w.Code)
+	}
+	// 处理失败及处理器 panic 后都释放预占，重试成功后重复的通知不再分发
+	if calls != 3 || statuses[0] != http.StatusInternalServerError || statuses[1] != http.StatusInternalServerError ||
+		statuses[2] != http.StatusOK || statuses[3] != http.StatusOK {
+		t.Errorf("calls = %d, statuses = %v", calls, statuses)
+	}
+}
+
+func TestNotifyMuxArchiveAndReplay(t *testing.T) {
+	p, kit := newTestWechatPay(t)
+	path := filepath.Join(t.TempDir(), "notify.jsonl")
+	archive, err := core.NewFileNotificationArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := p.NewNotifyMux()
+	mux.Archive = archive
+	mux.Store = core.NewMemoryNotificationStore(time.Hour)
+	var calls int
+	mux.HandleFunc(model.EventTransactionSuccess, func(ctx context.Context, n *model.Notification) error {
+		calls++
+		return nil
+	})
+	stale, _ := kit.NotificationRequestAt("/notify", "EV-1", model.EventTransactionSuccess, "transaction", `{}`, time.Now().Add(-time.Hour))
+	mux.ServeHTTP(httptest.NewRecorder(), stale)
+	fresh, _ := kit.NotificationRequestAt("/notify", "EV-2", model.EventTransactionSuccess, "transaction", `{}`, time.Now())
+	mux.ServeHTTP(httptest.NewRecorder(), fresh)
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1 (stale notification rejected)", calls)
+	}
+
+	// 回放时跳过时间戳检查及去重，签名错误的记录仍然拒绝
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []*core.ArchivedNotification
+	if err = core.ReadNotificationArchive(f, func(n *core.ArchivedNotification) error {
+		records = append(records, n)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records = %d, want 2", len(records))
+	}
+	for _, record := range records {
+		if err = mux.Replay(context.Background(), record); err != nil {
+			t.Errorf("Replay() error = %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+
+	// 回放接口要求使用共享的 secret 签名，同一签名只能使用一次
+	if _, err = mux.ReplayHandler(nil); err == nil {
+		t.Errorf("ReplayHandler() without secret should fail")
+	}
+	secret := []byte("replay-secret")
+	handler, err := mux.ReplayHandler(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(records[1])
+	newReplayRequest := func(secret []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/internal/wechatpay/replay", bytes.NewReader(body))
+		core.SignReplayRequest(r.Header, secret, body, time.Now())
+		return r
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newReplayRequest([]byte("other-secret")))
+	if w.Code != http.StatusUnauthorized || calls != 3 {
+		t.Errorf("replay with wrong secret status = %d, calls = %d", w.Code, calls)
+	}
+	r := newReplayRequest(secret)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || calls != 4 {
+		t.Errorf("replay status = %d, calls = %d", w.Code, calls)
+	}
+	replayed := httptest.NewRequest(http.MethodPost, "/internal/wechatpay/replay", bytes.NewReader(body))
+	replayed.Header = r.Header.Clone()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, replayed)
+	if w.Code != http.StatusUnauthorized || calls != 4 {
+		t.Errorf("reused replay signature status = %d, calls = %d", w.Code, calls)
+	}
+
+	records[0].Body = strings.Replace(records[0].Body, "EV-1", "EV-X", 1)
+	if err = mux.Replay(context.Background(), records[0]); err == nil {
+		t.Errorf("Replay() tampered body should fail")
+	}
+}
@@ -4,6 +4,13 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/base64"
+	"fmt"
+)
+
+// 回调通知及证书下载中加密数据的算法
+const (
+	AlgorithmAESGCM = "AEAD_AES_256_GCM" // 使用32字节APIv3密钥的AES-GCM
+	AlgorithmSM4GCM = "AEAD_SM4_GCM"     // 国密SM4-GCM，由APIv3密钥得到SM4密钥的方式没有公开文档，DecryptResource 暂不支持
 )
 
 func DecryptToByte(apiv3Key, associatedData, nonce, ciphertext string) ([]byte, error) {
@@ -31,3 +38,34 @@ func DecryptToString(apiv3Key, associatedData, nonce, ciphertext string) (string
 	certificateByte, err := DecryptToByte(apiv3Key, associatedData, nonce, ciphertext)
 	return string(certificateByte), err
 }
+
+// EncryptToString 使用APIv3密钥以 AEAD_AES_256_GCM 加密，返回Base64编码的密文，是 DecryptToByte 的逆运算
+//
+// 主要用于测试中构造回调通知及平台证书下载的回包
+func EncryptToString(apiv3Key, associatedData, nonce, plaintext string) (string, error) {
+	c, err := aes.NewCipher([]byte(apiv3Key))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := gcm.Seal(nil, []byte(nonce), []byte(plaintext), []byte(associatedData))
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptResource 按 algorithm 使用APIv3密钥解密回调通知及证书下载中的加密数据，algorithm 为空时按 AEAD_AES_256_GCM 处理
+//
+// apiv3Key 不是32字节时返回错误
+func DecryptResource(algorithm, apiv3Key, associatedData, nonce, ciphertext string) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmAESGCM, "":
+		if len(apiv3Key) != APIv3KeyLength {
+			return nil, fmt.Errorf("apiv3 key length is %d, must be %d bytes", len(apiv3Key), APIv3KeyLength)
+		}
+		return DecryptToByte(apiv3Key, associatedData, nonce, ciphertext)
+	default:
+		return nil, fmt.Errorf("unsupported resource algorithm %s", algorithm)
+	}
+}
@@ -2,11 +2,13 @@ package util
 
 import "testing"
 
+// 使用 EncryptToString 生成的测试数据，其他测试可以使用 fixtures 包生成
 const (
-	testAESUtilAPIV3Key       = ""
-	testAESUtilCiphertext     = ""
-	testAESUtilNonce          = ""
-	testAESUtilAssociatedData = ""
+	testAESUtilAPIV3Key       = "0123456789abcdef0123456789abcdef"
+	testAESUtilCiphertext     = "ChHCORH6RWPJ0pU/9LalmVHg69EkMB+Cb16+A9UmL4eD5fu5FX4DPiUSqrt/RhDHNmJgGwFKYGPvm/i01G6VKTsaTYx0OuhqY2EWUZBPN4TDjw=="
+	testAESUtilNonce          = "a1b2c3d4e5f6"
+	testAESUtilAssociatedData = "certificate"
+	testAESUtilCertificate    = "-----BEGIN CERTIFICATE-----\nMIIDfixture\n-----END CERTIFICATE-----\n"
 )
 
 func TestDecryptToString(t *testing.T) {
@@ -26,10 +28,31 @@ func TestDecryptToString(t *testing.T) {
 			name: "decrypt certificate",
 			args: args{
 				apiv3Key:       testAESUtilAPIV3Key,
-				associatedData: testAESUtilCiphertext,
+				associatedData: testAESUtilAssociatedData,
 				nonce:          testAESUtilNonce,
-				ciphertext:     testAESUtilAssociatedData,
+				ciphertext:     testAESUtilCiphertext,
 			},
+			wantCertificate: testAESUtilCertificate,
+		},
+		{
+			name: "wrong associated data",
+			args: args{
+				apiv3Key:       testAESUtilAPIV3Key,
+				associatedData: "transaction",
+				nonce:          testAESUtilNonce,
+				ciphertext:     testAESUtilCiphertext,
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong apiv3 key",
+			args: args{
+				apiv3Key:       "fedcba9876543210fedcba9876543210",
+				associatedData: testAESUtilAssociatedData,
+				nonce:          testAESUtilNonce,
+				ciphertext:     testAESUtilCiphertext,
+			},
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
@@ -45,3 +68,17 @@ func TestDecryptToString(t *testing.T) {
 		})
 	}
 }
+
+func TestDecryptResource(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmAESGCM, ""} {
+		plaintext, err := DecryptResource(algorithm, testAESUtilAPIV3Key, testAESUtilAssociatedData, testAESUtilNonce, testAESUtilCiphertext)
+		if err != nil || string(plaintext) != testAESUtilCertificate {
+			t.Errorf("DecryptResource(%q) = %s, %v", algorithm, plaintext, err)
+		}
+	}
+	for _, algorithm := range []string{AlgorithmSM4GCM, "AEAD_UNKNOWN"} {
+		if _, err := DecryptResource(algorithm, testAESUtilAPIV3Key, testAESUtilAssociatedData, testAESUtilNonce, testAESUtilCiphertext); err == nil {
+			t.Errorf("DecryptResource(%q) should fail", algorithm)
+		}
+	}
+}
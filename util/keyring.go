@@ -0,0 +1,54 @@
+package util
+
+import (
+	"fmt"
+)
+
+// APIv3KeyLength APIv3密钥长度
+const APIv3KeyLength = 32
+
+// Keyring 有序的APIv3密钥环
+//
+// 在商户平台重置APIv3密钥后，仍在途的回调通知和证书可能使用旧密钥加密，解密时按顺序尝试每一个密钥
+type Keyring struct {
+	keys []string
+}
+
+// NewKeyring 创建APIv3密钥环，keys 按尝试顺序排列，通常新密钥在前；每个密钥必须是32字节
+func NewKeyring(keys ...string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("apiv3 keyring need at least one key")
+	}
+	for i, key := range keys {
+		if len(key) != APIv3KeyLength {
+			return nil, fmt.Errorf("apiv3 key #%d length is %d, must be %d bytes", i, len(key), APIv3KeyLength)
+		}
+	}
+	return &Keyring{keys: append([]string(nil), keys...)}, nil
+}
+
+// Len 密钥环中密钥的数量
+func (k *Keyring) Len() int {
+	return len(k.keys)
+}
+
+// Primary 密钥环中的第一个密钥
+func (k *Keyring) Primary() string {
+	return k.keys[0]
+}
+
+// DecryptToByte 按顺序使用密钥环中的密钥以 AEAD_AES_256_GCM 解密，返回明文及解密成功的密钥序号
+func (k *Keyring) DecryptToByte(associatedData, nonce, ciphertext string) (plaintext []byte, keyIndex int, err error) {
+	return k.DecryptResource(AlgorithmAESGCM, associatedData, nonce, ciphertext)
+}
+
+// DecryptResource 按 algorithm 依次使用密钥环中的密钥解密，返回明文及解密成功的密钥序号
+func (k *Keyring) DecryptResource(algorithm, associatedData, nonce, ciphertext string) (plaintext []byte, keyIndex int, err error) {
+	for i, key := range k.keys {
+		plaintext, err = DecryptResource(algorithm, key, associatedData, nonce, ciphertext)
+		if err == nil {
+			return plaintext, i, nil
+		}
+	}
+	return nil, -1, fmt.Errorf("decrypt with %d apiv3 keys failed, last err:%w", len(k.keys), err)
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"testing"
+)
+
+func TestKeyringDecryptToByte(t *testing.T) {
+	oldKey := "0123456789abcdef0123456789abcdef"
+	newKey := "fedcba9876543210fedcba9876543210"
+	nonce := "0123456789ab"
+	block, _ := aes.NewCipher([]byte(oldKey))
+	gcm, _ := cipher.NewGCM(block)
+	ciphertext := base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), []byte("plaintext"), []byte("transaction")))
+
+	keyring, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, keyIndex, err := keyring.DecryptToByte("transaction", nonce, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptToByte() error = %v", err)
+	}
+	if string(plaintext) != "plaintext" || keyIndex != 1 {
+		t.Errorf("DecryptToByte() = %s, %d, want plaintext, 1", plaintext, keyIndex)
+	}
+
+	keyring, _ = NewKeyring(newKey)
+	if _, _, err = keyring.DecryptToByte("transaction", nonce, ciphertext); err == nil {
+		t.Errorf("DecryptToByte() without old key should fail")
+	}
+	if _, err = NewKeyring(newKey, "short"); err == nil {
+		t.Errorf("NewKeyring() with short key should fail")
+	}
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 微信支付商户平台下载的商户API证书文件名
+const (
+	MerchantKeyFile    = "apiclient_key.pem"  // 商户私钥
+	MerchantCertFile   = "apiclient_cert.pem" // 商户证书
+	MerchantPKCS12File = "apiclient_cert.p12" // 商户PKCS#12证书
+)
+
+// MerchantCredentials 商户API证书
+type MerchantCredentials struct {
+	PrivateKey   *rsa.PrivateKey   // 商户私钥
+	Certificate  *x509.Certificate // 商户证书
+	SerialNumber string            // 商户证书序列号
+}
+
+// GetCertificateSerialNumber 获取证书序列号（大写16进制）
+func GetCertificateSerialNumber(certificate *x509.Certificate) string {
+	return strings.ToUpper(hex.EncodeToString(certificate.SerialNumber.Bytes()))
+}
+
+// LoadMerchantCredentials 从目录中加载商户API证书
+//
+// 优先使用 apiclient_key.pem 和 apiclient_cert.pem，不存在时使用 apiclient_cert.p12（密码为商户号 mchid）。
+// 私钥支持PKCS#8和PKCS#1格式，加载后会校验私钥与证书公钥是否匹配。
+func LoadMerchantCredentials(dir, mchid string) (*MerchantCredentials, error) {
+	var privateKey *rsa.PrivateKey
+	var certificate *x509.Certificate
+	keyPath := filepath.Join(dir, MerchantKeyFile)
+	certPath := filepath.Join(dir, MerchantCertFile)
+	if fileExists(keyPath) && fileExists(certPath) {
+		privateKeyBytes, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read private pem file err:%s", err.Error())
+		}
+		if privateKey, err = loadAnyPrivateKey(privateKeyBytes, mchid); err != nil {
+			return nil, err
+		}
+		if certificate, err = LoadCertificateWithPath(certPath); err != nil {
+			return nil, err
+		}
+	} else if p12Path := filepath.Join(dir, MerchantPKCS12File); fileExists(p12Path) {
+		var err error
+		if privateKey, certificate, err = LoadPKCS12WithPath(p12Path, mchid); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("no merchant credentials found in %s", dir)
+	}
+
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok || !privateKey.PublicKey.Equal(publicKey) {
+		return nil, fmt.Errorf("merchant private key does not match certificate")
+	}
+	return &MerchantCredentials{
+		PrivateKey:   privateKey,
+		Certificate:  certificate,
+		SerialNumber: GetCertificateSerialNumber(certificate),
+	}, nil
+}
+
+// loadAnyPrivateKey 按PEM类型加载PKCS#8、PKCS#1或加密的私钥
+func loadAnyPrivateKey(privateKeyBytes []byte, password string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("decode private key err")
+	}
+	switch {
+	case x509.IsEncryptedPEMBlock(block): //nolint:staticcheck
+		return LoadEncryptedPrivateKey(privateKeyBytes, password)
+	case block.Type == "RSA PRIVATE KEY":
+		return LoadPKCS1PrivateKey(privateKeyBytes)
+	default:
+		return LoadPrivateKey(privateKeyBytes)
+	}
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,148 @@
+package util_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/util"
+)
+
+func writeMerchantFiles(t *testing.T, dir string, privateKey *rsa.PrivateKey, certificate *x509.Certificate) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if err := ioutil.WriteFile(filepath.Join(dir, util.MerchantCertFile), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, util.MerchantKeyFile), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadMerchantCredentials(t *testing.T) {
+	privateKey, certificate := fixtures.NewCertificate("1900009191")
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	dir := t.TempDir()
+	writeMerchantFiles(t, dir, privateKey, certificate)
+	credentials, err := util.LoadMerchantCredentials(dir, "1900009191")
+	if err != nil {
+		t.Fatalf("LoadMerchantCredentials() error = %v", err)
+	}
+	if serialNumber := util.GetCertificateSerialNumber(certificate); credentials.SerialNumber != serialNumber {
+		t.Errorf("LoadMerchantCredentials() serial = %s, want %s", credentials.SerialNumber, serialNumber)
+	}
+
+	mismatch := t.TempDir()
+	writeMerchantFiles(t, mismatch, otherKey, certificate)
+	if _, err = util.LoadMerchantCredentials(mismatch, "1900009191"); err == nil {
+		t.Errorf("LoadMerchantCredentials() with mismatched key should fail")
+	}
+	if _, err = util.LoadMerchantCredentials(t.TempDir(), "1900009191"); err == nil {
+		t.Errorf("LoadMerchantCredentials() with empty dir should fail")
+	}
+}
+
+// testdata 中的商户证书由 openssl 生成，密码均为商户号 1900009191：
+//
+//	openssl req -x509 -newkey rsa:2048 -nodes -keyout key.pem -out apiclient_cert.pem -days 36500 -subj "/CN=1900009191" -set_serial 0x1F2E3D4C
+//	openssl pkcs12 -export -legacy -in apiclient_cert.pem -inkey key.pem -out apiclient_cert.p12 -passout pass:1900009191
+//	openssl rsa -in key.pem -traditional -aes256 -passout pass:1900009191 -out apiclient_key_encrypted.pem
+//	openssl pkcs8 -topk8 -in key.pem -v2 aes-256-cbc -passout pass:1900009191 -out apiclient_key_pkcs8_encrypted.pem
+const testMerchantSerialNumber = "1F2E3D4C"
+
+// readTestdata 读取 testdata 中的文件
+func readTestdata(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// matchesTestCertificate 私钥是否与 testdata 中的商户证书匹配
+func matchesTestCertificate(t *testing.T, privateKey *rsa.PrivateKey) bool {
+	certificate, err := util.LoadCertificate(readTestdata(t, util.MerchantCertFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey != nil && privateKey.PublicKey.Equal(certificate.PublicKey)
+}
+
+func TestLoadPKCS12(t *testing.T) {
+	pfxData := readTestdata(t, util.MerchantPKCS12File)
+	key, certificate, err := util.LoadPKCS12(pfxData, "1900009191")
+	if err != nil {
+		t.Fatalf("LoadPKCS12() error = %v", err)
+	}
+	if !matchesTestCertificate(t, key) || util.GetCertificateSerialNumber(certificate) != testMerchantSerialNumber {
+		t.Errorf("LoadPKCS12() returned wrong key or certificate")
+	}
+	if _, _, err = util.LoadPKCS12(pfxData, "1900009192"); err == nil {
+		t.Errorf("LoadPKCS12() with wrong password should fail")
+	}
+}
+
+func TestLoadEncryptedPrivateKey(t *testing.T) {
+	keyPEM := readTestdata(t, "apiclient_key_encrypted.pem")
+	key, err := util.LoadEncryptedPrivateKey(keyPEM, "1900009191")
+	if err != nil || !matchesTestCertificate(t, key) {
+		t.Fatalf("LoadEncryptedPrivateKey() = %v, %v", key != nil, err)
+	}
+	if _, err = util.LoadEncryptedPrivateKey(keyPEM, "wrong"); err == nil {
+		t.Errorf("LoadEncryptedPrivateKey() with wrong password should fail")
+	}
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	plainPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if _, err = util.LoadEncryptedPrivateKey(plainPEM, "1900009191"); err == nil {
+		t.Errorf("LoadEncryptedPrivateKey() with unencrypted key should fail")
+	}
+
+	// PKCS#8加密格式返回明确的不支持错误，而不是解析错误
+	pkcs8PEM := readTestdata(t, "apiclient_key_pkcs8_encrypted.pem")
+	for _, load := range []func() (*rsa.PrivateKey, error){
+		func() (*rsa.PrivateKey, error) { return util.LoadEncryptedPrivateKey(pkcs8PEM, "1900009191") },
+		func() (*rsa.PrivateKey, error) { return util.LoadPrivateKey(pkcs8PEM) },
+	} {
+		if _, err = load(); err == nil || !strings.Contains(err.Error(), "unsupported pkcs8 encrypted private key") {
+			t.Errorf("load pkcs8 encrypted key error = %v", err)
+		}
+	}
+
+	// 商户目录中的加密私钥使用商户号作为密码
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{util.MerchantKeyFile: keyPEM, util.MerchantCertFile: readTestdata(t, util.MerchantCertFile)} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = util.LoadMerchantCredentials(dir, "1900009191"); err != nil {
+		t.Errorf("LoadMerchantCredentials() with encrypted key error = %v", err)
+	}
+	if _, err = util.LoadMerchantCredentials(dir, "1900009192"); err == nil {
+		t.Errorf("LoadMerchantCredentials() with wrong mchid should fail")
+	}
+}
+
+func TestLoadMerchantCredentialsPKCS12(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, util.MerchantPKCS12File), readTestdata(t, util.MerchantPKCS12File), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := util.LoadMerchantCredentials(dir, "1900009191")
+	if err != nil {
+		t.Fatalf("LoadMerchantCredentials() error = %v", err)
+	}
+	if !matchesTestCertificate(t, credentials.PrivateKey) || credentials.SerialNumber != testMerchantSerialNumber {
+		t.Errorf("LoadMerchantCredentials() = %+v", credentials)
+	}
+	if _, err = util.LoadMerchantCredentials(dir, "1900009192"); err == nil {
+		t.Errorf("LoadMerchantCredentials() with wrong mchid should fail")
+	}
+}
@@ -22,12 +22,24 @@ func LoadCertificate(certificateBytes []byte) (certificate *x509.Certificate, er
 	return certificate, nil
 }
 
+// CertificateToPEM 把证书编码为PEM格式的文本，是 LoadCertificate 的逆运算
+func CertificateToPEM(certificate *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+}
+
+// errPKCS8Encrypted 不支持PKCS#8加密格式（ENCRYPTED PRIVATE KEY）的私钥
+var errPKCS8Encrypted = errors.New("unsupported pkcs8 encrypted private key (ENCRYPTED PRIVATE KEY), " +
+	"decrypt it with: openssl pkcs8 -in <encrypted key> -out apiclient_key.pem")
+
 // LoadPrivateKey 通过私钥的文本内容加载私钥
 func LoadPrivateKey(privateKeyBytes []byte) (privateKey *rsa.PrivateKey, err error) {
 	block, _ := pem.Decode(privateKeyBytes)
 	if block == nil {
 		return nil, fmt.Errorf("decode private key err")
 	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, errPKCS8Encrypted
+	}
 	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("parse private key err:%s", err.Error())
@@ -39,6 +51,57 @@ func LoadPrivateKey(privateKeyBytes []byte) (privateKey *rsa.PrivateKey, err err
 	return privateKey, nil
 }
 
+// LoadPKCS1PrivateKey 通过PKCS#1格式（RSA PRIVATE KEY）私钥的文本内容加载私钥
+func LoadPKCS1PrivateKey(privateKeyBytes []byte) (privateKey *rsa.PrivateKey, err error) {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("decode private key err")
+	}
+	privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse pkcs1 private key err:%s", err.Error())
+	}
+	return privateKey, nil
+}
+
+// LoadEncryptedPrivateKey 通过加密的私钥文本内容加载私钥
+//
+// 支持 Proc-Type: 4,ENCRYPTED 的传统加密PEM格式，解密后的内容可以是PKCS#1或PKCS#8格式；
+// 不支持 openssl 3 默认输出的PKCS#8加密格式（ENCRYPTED PRIVATE KEY），此时返回错误
+func LoadEncryptedPrivateKey(privateKeyBytes []byte, password string) (privateKey *rsa.PrivateKey, err error) {
+	block, _ := pem.Decode(privateKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("decode private key err")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, errPKCS8Encrypted
+	}
+	if !x509.IsEncryptedPEMBlock(block) { //nolint:staticcheck // 微信支付历史私钥仍使用传统加密PEM格式
+		return nil, fmt.Errorf("private key is not encrypted")
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(password)) //nolint:staticcheck
+	if err != nil {
+		return nil, fmt.Errorf("decrypt private key err:%s", err.Error())
+	}
+	return parseRSAPrivateKey(der)
+}
+
+// parseRSAPrivateKey 解析PKCS#1或PKCS#8格式的RSA私钥
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if privateKey, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return privateKey, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key err:%s", err.Error())
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not rsa private key")
+	}
+	return privateKey, nil
+}
+
 // LoadPublicKey 通过公钥的文本内容加载公钥
 func LoadPublicKey(publicKeyBytes []byte) (publicKey *rsa.PublicKey, err error) {
 	block, _ := pem.Decode([]byte(publicKeyBytes))
@@ -82,3 +145,21 @@ func LoadPublicKeyWithPath(path string) (publicKey *rsa.PublicKey, err error) {
 	}
 	return LoadPublicKey(publicKeyBytes)
 }
+
+// LoadPKCS1PrivateKeyWithPath 通过PKCS#1格式私钥的文件路径加载私钥
+func LoadPKCS1PrivateKeyWithPath(path string) (privateKey *rsa.PrivateKey, err error) {
+	privateKeyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private pem file err:%s", err.Error())
+	}
+	return LoadPKCS1PrivateKey(privateKeyBytes)
+}
+
+// LoadEncryptedPrivateKeyWithPath 通过加密私钥的文件路径加载私钥
+func LoadEncryptedPrivateKeyWithPath(path, password string) (privateKey *rsa.PrivateKey, err error) {
+	privateKeyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private pem file err:%s", err.Error())
+	}
+	return LoadEncryptedPrivateKey(privateKeyBytes, password)
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// LoadPKCS12 通过PKCS#12证书（apiclient_cert.p12）的内容加载商户私钥和商户证书
+//
+// 微信支付下发的p12证书默认密码为商户号
+func LoadPKCS12(pfxData []byte, password string) (privateKey *rsa.PrivateKey, certificate *x509.Certificate, err error) {
+	key, certificate, err := pkcs12.Decode(pfxData, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode pkcs12 err:%s", err.Error())
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("pkcs12 private key is not rsa private key")
+	}
+	return privateKey, certificate, nil
+}
+
+// LoadPKCS12WithPath 通过PKCS#12证书的文件路径加载商户私钥和商户证书
+func LoadPKCS12WithPath(path, password string) (privateKey *rsa.PrivateKey, certificate *x509.Certificate, err error) {
+	pfxData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read pkcs12 file err:%s", err.Error())
+	}
+	return LoadPKCS12(pfxData, password)
+}
@@ -0,0 +1,323 @@
+package util
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SensitiveTag 敏感信息字段的结构体标签，如 `json:"user_name" wechatpay:"encrypt"`
+//
+// 请求中的字段使用平台证书加密，回包中的字段使用商户私钥解密
+const SensitiveTag = "wechatpay"
+
+// isEncryptField 字段是否标记为需要加密的敏感信息
+func isEncryptField(field reflect.StructField) bool {
+	for _, option := range strings.Split(field.Tag.Get(SensitiveTag), ",") {
+		if strings.TrimSpace(option) == "encrypt" {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptSensitiveFields 使用平台证书加密结构体中标记为 `wechatpay:"encrypt"` 的字符串字段
+//
+// 不会修改 v 本身，返回加密后的副本以及是否有字段被加密；空字符串不加密，标记的字段不是字符串类型时返回错误
+func EncryptSensitiveFields(v interface{}, certificate *x509.Certificate) (interface{}, bool, error) {
+	if v == nil {
+		return v, false, nil
+	}
+	encrypted, ok, err := encryptValue(reflect.ValueOf(v), certificate)
+	if err != nil || !ok {
+		return v, false, err
+	}
+	return encrypted.Interface(), true, nil
+}
+
+// encryptValue 递归加密敏感信息字段，有字段被加密时返回新的值
+func encryptValue(v reflect.Value, certificate *x509.Certificate) (reflect.Value, bool, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, false, nil
+		}
+		elem, ok, err := encryptValue(v.Elem(), certificate)
+		if err != nil || !ok {
+			return v, false, err
+		}
+		ptr := reflect.New(elem.Type())
+		ptr.Elem().Set(elem)
+		return ptr, true, nil
+	case reflect.Struct:
+		var copied reflect.Value
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" { // 未导出字段
+				continue
+			}
+			var newField reflect.Value
+			if isEncryptField(field) {
+				if field.Type.Kind() != reflect.String {
+					return v, false, fmt.Errorf("sensitive field %s.%s must be string, got %s", v.Type().Name(), field.Name, field.Type)
+				}
+				if v.Field(i).String() == "" {
+					continue
+				}
+				ciphertext, err := EncryptOAEPWithCertificate(v.Field(i).String(), certificate)
+				if err != nil {
+					return v, false, err
+				}
+				newField = reflect.ValueOf(ciphertext).Convert(field.Type)
+			} else {
+				encrypted, ok, err := encryptValue(v.Field(i), certificate)
+				if err != nil {
+					return v, false, err
+				}
+				if !ok {
+					continue
+				}
+				newField = encrypted
+			}
+			if !copied.IsValid() {
+				copied = reflect.New(v.Type()).Elem()
+				copied.Set(v)
+			}
+			copied.Field(i).Set(newField)
+		}
+		if !copied.IsValid() {
+			return v, false, nil
+		}
+		return copied, true, nil
+	case reflect.Slice, reflect.Array:
+		var copied reflect.Value
+		for i := 0; i < v.Len(); i++ {
+			encrypted, ok, err := encryptValue(v.Index(i), certificate)
+			if err != nil {
+				return v, false, err
+			}
+			if !ok {
+				continue
+			}
+			if !copied.IsValid() {
+				if v.Kind() == reflect.Slice {
+					copied = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+					reflect.Copy(copied, v)
+				} else {
+					copied = reflect.New(v.Type()).Elem()
+					copied.Set(v)
+				}
+			}
+			copied.Index(i).Set(encrypted)
+		}
+		if !copied.IsValid() {
+			return v, false, nil
+		}
+		return copied, true, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, false, nil
+		}
+		var copied reflect.Value
+		iter := v.MapRange()
+		for iter.Next() {
+			encrypted, ok, err := encryptValue(iter.Value(), certificate)
+			if err != nil {
+				return v, false, err
+			}
+			if !ok {
+				continue
+			}
+			if !copied.IsValid() {
+				copied = reflect.MakeMapWithSize(v.Type(), v.Len())
+				for _, key := range v.MapKeys() {
+					copied.SetMapIndex(key, v.MapIndex(key))
+				}
+			}
+			copied.SetMapIndex(iter.Key(), encrypted)
+		}
+		if !copied.IsValid() {
+			return v, false, nil
+		}
+		return copied, true, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, false, nil
+		}
+		return encryptValue(v.Elem(), certificate)
+	}
+	return v, false, nil
+}
+
+// SensitiveFieldError 敏感信息字段解密错误
+type SensitiveFieldError struct {
+	Field string // 字段路径，如 Complaints[0].PayerPhone
+	Err   error  // 解密错误
+}
+
+// Error 返回错误信息
+func (e *SensitiveFieldError) Error() string {
+	return fmt.Sprintf("decrypt sensitive field %s err:%v", e.Field, e.Err)
+}
+
+// Unwrap 返回解密错误
+func (e *SensitiveFieldError) Unwrap() error {
+	return e.Err
+}
+
+// DecryptSensitiveFields 使用商户私钥解密结构体中标记为 `wechatpay:"encrypt"` 的字符串字段，v 必须是指针
+//
+// privateKey 解密失败时依次尝试 fallbackKeys，用于商户证书轮换期间新旧证书同时有效；
+// 遇到第一个解密失败的字段即返回 *SensitiveFieldError
+func DecryptSensitiveFields(v interface{}, privateKey *rsa.PrivateKey, fallbackKeys ...*rsa.PrivateKey) error {
+	var fieldErr *SensitiveFieldError
+	err := decryptFields(v, decryptWithKeys(privateKey, fallbackKeys), func(err *SensitiveFieldError) bool {
+		fieldErr = err
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if fieldErr != nil {
+		return fieldErr
+	}
+	return nil
+}
+
+// DecryptSensitiveFieldsCollect 解密全部标记为 `wechatpay:"encrypt"` 的字段，返回所有解密失败的字段，失败的字段保留密文
+func DecryptSensitiveFieldsCollect(v interface{}, privateKey *rsa.PrivateKey,
+	fallbackKeys ...*rsa.PrivateKey) ([]*SensitiveFieldError, error) {
+	var fieldErrs []*SensitiveFieldError
+	err := decryptFields(v, decryptWithKeys(privateKey, fallbackKeys), func(err *SensitiveFieldError) bool {
+		fieldErrs = append(fieldErrs, err)
+		return true
+	})
+	return fieldErrs, err
+}
+
+// FindSensitiveField 返回 v 中第一个有值的 `wechatpay:"encrypt"` 字段路径，没有时返回空字符串，不修改 v
+func FindSensitiveField(v interface{}) string {
+	var field string
+	_ = decryptFields(v, func(ciphertext string) (string, error) {
+		return "", errSensitiveFieldFound
+	}, func(err *SensitiveFieldError) bool {
+		field = err.Field
+		return false
+	})
+	return field
+}
+
+var errSensitiveFieldFound = errors.New("sensitive field found")
+
+// decryptWithKeys 返回先使用 privateKey、失败后依次使用 fallbackKeys 解密的函数，全部失败时返回 privateKey 的错误
+func decryptWithKeys(privateKey *rsa.PrivateKey, fallbackKeys []*rsa.PrivateKey) func(string) (string, error) {
+	return func(ciphertext string) (string, error) {
+		plaintext, err := DecryptOAEP(ciphertext, privateKey)
+		for _, key := range fallbackKeys {
+			if err == nil {
+				break
+			}
+			if fallback, fallbackErr := DecryptOAEP(ciphertext, key); fallbackErr == nil {
+				plaintext, err = fallback, nil
+			}
+		}
+		return plaintext, err
+	}
+}
+
+func decryptFields(v interface{}, decrypt func(string) (string, error), report func(*SensitiveFieldError) bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decrypt sensitive fields need non-nil pointer, got %T", v)
+	}
+	decryptValue(rv.Elem(), rv.Elem().Type().Name(), decrypt, report)
+	return nil
+}
+
+// decryptValue 递归解密敏感信息字段，report 返回 false 时停止
+func decryptValue(v reflect.Value, path string, decrypt func(string) (string, error), report func(*SensitiveFieldError) bool) bool {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return true
+		}
+		return decryptValue(v.Elem(), path, decrypt, report)
+	case reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		if v.Elem().Kind() == reflect.Ptr {
+			return decryptValue(v.Elem(), path, decrypt, report)
+		}
+		// 接口中的非指针值不可寻址，解密副本后写回
+		return decryptCopy(v.Elem(), path, decrypt, report, func(copied reflect.Value) {
+			if v.CanSet() {
+				v.Set(copied)
+			}
+		})
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key()
+			// map 的值不可寻址，解密副本后写回
+			if !decryptCopy(iter.Value(), fmt.Sprintf("%s[%v]", path, key.Interface()), decrypt, report, func(copied reflect.Value) {
+				v.SetMapIndex(key, copied)
+			}) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" { // 未导出字段
+				continue
+			}
+			fieldPath := path + "." + field.Name
+			if isEncryptField(field) && field.Type.Kind() == reflect.String {
+				ciphertext := v.Field(i).String()
+				if ciphertext == "" || !v.Field(i).CanSet() {
+					continue
+				}
+				plaintext, err := decrypt(ciphertext)
+				if err != nil {
+					if !report(&SensitiveFieldError{Field: fieldPath, Err: err}) {
+						return false
+					}
+					continue
+				}
+				v.Field(i).SetString(plaintext)
+				continue
+			}
+			if !decryptValue(v.Field(i), fieldPath, decrypt, report) {
+				return false
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !decryptValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), decrypt, report) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// decryptCopy 解密 v 的可寻址副本，有字段被解密时调用 store 写回
+func decryptCopy(v reflect.Value, path string, decrypt func(string) (string, error),
+	report func(*SensitiveFieldError) bool, store func(reflect.Value)) bool {
+	copied := reflect.New(v.Type()).Elem()
+	copied.Set(v)
+	changed := false
+	ok := decryptValue(copied, path, func(ciphertext string) (string, error) {
+		plaintext, err := decrypt(ciphertext)
+		changed = changed || err == nil
+		return plaintext, err
+	}, report)
+	if changed {
+		store(copied)
+	}
+	return ok
+}
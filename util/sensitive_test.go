@@ -0,0 +1,111 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testSensitiveReply struct {
+	Data []struct {
+		PayerPhone string `json:"payer_phone" wechatpay:"encrypt"`
+	} `json:"data"`
+}
+
+func TestDecryptSensitiveFields(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ciphertext, err := EncryptOAEPWithPublicKey("13800138000", &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReply := func() *testSensitiveReply {
+		reply := &testSensitiveReply{}
+		reply.Data = make([]struct {
+			PayerPhone string `json:"payer_phone" wechatpay:"encrypt"`
+		}, 2)
+		reply.Data[0].PayerPhone = "invalid"
+		reply.Data[1].PayerPhone = ciphertext
+		return reply
+	}
+
+	reply := newReply()
+	err = DecryptSensitiveFields(reply, privateKey)
+	var fieldErr *SensitiveFieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "testSensitiveReply.Data[0].PayerPhone" {
+		t.Fatalf("DecryptSensitiveFields() error = %v, want field error of Data[0]", err)
+	}
+
+	reply = newReply()
+	fieldErrs, err := DecryptSensitiveFieldsCollect(reply, privateKey)
+	if err != nil || len(fieldErrs) != 1 {
+		t.Fatalf("DecryptSensitiveFieldsCollect() = %v, %v, want 1 field error", fieldErrs, err)
+	}
+	if reply.Data[0].PayerPhone != "invalid" || reply.Data[1].PayerPhone != "13800138000" {
+		t.Errorf("DecryptSensitiveFieldsCollect() got %+v", reply.Data)
+	}
+
+	reply = newReply()
+	if field := FindSensitiveField(reply); field != "testSensitiveReply.Data[0].PayerPhone" || reply.Data[1].PayerPhone != ciphertext {
+		t.Errorf("FindSensitiveField() = %s", field)
+	}
+	if field := FindSensitiveField(&testSensitiveReply{}); field != "" {
+		t.Errorf("FindSensitiveField() empty reply = %s", field)
+	}
+}
+
+func TestEncryptSensitiveFieldsRejectsNonString(t *testing.T) {
+	type request struct {
+		Name    string      `json:"name" wechatpay:"encrypt"`
+		Account interface{} `json:"account" wechatpay:"encrypt"`
+	}
+	if _, _, err := EncryptSensitiveFields(request{Name: "张三", Account: "6222"}, nil); err == nil {
+		t.Errorf("EncryptSensitiveFields() with interface field should fail")
+	}
+}
+
+func TestSensitiveFieldsInMapsAndInterfaces(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+
+	type person struct {
+		Name string `json:"name" wechatpay:"encrypt"`
+	}
+	type request struct {
+		Persons map[string]person `json:"persons"`
+		Extra   interface{}       `json:"extra"`
+	}
+	v := request{Persons: map[string]person{"payer": {Name: "张三"}}, Extra: person{Name: "李四"}}
+	encrypted, ok, err := EncryptSensitiveFields(v, certificate)
+	if err != nil || !ok {
+		t.Fatalf("EncryptSensitiveFields() = %v, %v", ok, err)
+	}
+	if v.Persons["payer"].Name != "张三" || v.Extra.(person).Name != "李四" {
+		t.Errorf("EncryptSensitiveFields() should not modify the input")
+	}
+
+	reply := encrypted.(request)
+	if field := FindSensitiveField(&reply); field != "request.Persons[payer].Name" && field != "request.Extra.Name" {
+		t.Errorf("FindSensitiveField() = %s", field)
+	}
+	if err = DecryptSensitiveFields(&reply, privateKey); err != nil {
+		t.Fatalf("DecryptSensitiveFields() error = %v", err)
+	}
+	if reply.Persons["payer"].Name != "张三" || reply.Extra.(person).Name != "李四" {
+		t.Errorf("DecryptSensitiveFields() got %+v", reply)
+	}
+}
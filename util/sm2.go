@@ -0,0 +1,60 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+// LoadSM2PrivateKey 通过私钥的文本内容加载SM2私钥
+func LoadSM2PrivateKey(privateKeyBytes []byte) (privateKey *sm2.PrivateKey, err error) {
+	privateKey, err = gmx509.ReadPrivateKeyFromPem(privateKeyBytes, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parse sm2 private key err:%s", err.Error())
+	}
+	return privateKey, nil
+}
+
+// LoadSM2Certificate 通过证书的文本内容加载SM2证书
+//
+// 返回证书的PublicKey为*sm2.PublicKey
+func LoadSM2Certificate(certificateBytes []byte) (certificate *x509.Certificate, err error) {
+	block, _ := pem.Decode(certificateBytes)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("解码证书失败！")
+	}
+	certificate, err = gmx509.ParseSm2CertifateToX509(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse sm2 certificate err:%s", err.Error())
+	}
+	// gmsm 解析出的公钥为SM2曲线上的 *ecdsa.PublicKey，转换为 *sm2.PublicKey 以便验签
+	publicKey, ok := certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != sm2.P256Sm2() {
+		return nil, fmt.Errorf("certificate is not sm2 certificate")
+	}
+	certificate.PublicKey = &sm2.PublicKey{Curve: publicKey.Curve, X: publicKey.X, Y: publicKey.Y}
+	return certificate, nil
+}
+
+// LoadSM2PrivateKeyWithPath 通过私钥的文件路径加载SM2私钥
+func LoadSM2PrivateKeyWithPath(path string) (privateKey *sm2.PrivateKey, err error) {
+	privateKeyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private pem file err:%s", err.Error())
+	}
+	return LoadSM2PrivateKey(privateKeyBytes)
+}
+
+// LoadSM2CertificateWithPath 通过证书的文件路径加载SM2证书
+func LoadSM2CertificateWithPath(path string) (certificate *x509.Certificate, err error) {
+	certificateBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate pem file err:%s", err.Error())
+	}
+	return LoadSM2Certificate(certificateBytes)
+}
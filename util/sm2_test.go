@@ -0,0 +1,36 @@
+package util
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+func TestLoadSM2Certificate(t *testing.T) {
+	privateKey, _ := sm2.GenerateKey(rand.Reader)
+	template := &gmx509.Certificate{
+		SerialNumber: big.NewInt(0x1F2E3D4C),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certPEM, err := gmx509.CreateCertificateToPem(template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := LoadSM2Certificate(certPEM)
+	if err != nil {
+		t.Fatalf("LoadSM2Certificate() error = %v", err)
+	}
+	publicKey, ok := certificate.PublicKey.(*sm2.PublicKey)
+	if !ok || publicKey.X.Cmp(privateKey.X) != 0 || publicKey.Y.Cmp(privateKey.Y) != 0 {
+		t.Fatalf("LoadSM2Certificate() public key = %T", certificate.PublicKey)
+	}
+	signature, _ := privateKey.Sign(rand.Reader, []byte("message"), nil)
+	if !publicKey.Verify([]byte("message"), signature) {
+		t.Errorf("signature should verify with certificate public key")
+	}
+}
@@ -0,0 +1,17 @@
+package util
+
+import (
+	"encoding/hex"
+
+	"github.com/tjfoc/gmsm/sm3"
+)
+
+// SM3Sum 计算信息的SM3摘要
+func SM3Sum(message []byte) []byte {
+	return sm3.Sm3Sum(message)
+}
+
+// SM3SumHex 计算信息的SM3摘要，并以16进制字符串返回
+func SM3SumHex(message []byte) string {
+	return hex.EncodeToString(SM3Sum(message))
+}
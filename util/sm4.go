@@ -0,0 +1,31 @@
+package util
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+
+	"github.com/tjfoc/gmsm/sm4"
+)
+
+// DecryptSM4ToByte 使用SM4-GCM算法解密，key 为16字节的SM4密钥
+func DecryptSM4ToByte(key, associatedData, nonce, ciphertext string) ([]byte, error) {
+	decodedCiphertext, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	c, err := sm4.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, []byte(nonce), decodedCiphertext, []byte(associatedData))
+}
+
+// DecryptSM4ToString 使用SM4-GCM算法解密，并返回字符串
+func DecryptSM4ToString(key, associatedData, nonce, ciphertext string) (string, error) {
+	plaintext, err := DecryptSM4ToByte(key, associatedData, nonce, ciphertext)
+	return string(plaintext), err
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 8998 附录A.1 的 SM4-GCM 测试向量
+func TestDecryptSM4ToString(t *testing.T) {
+	decode := func(s string) string {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	key := decode("0123456789ABCDEFFEDCBA9876543210")
+	nonce := decode("00001234567800000000ABCD")
+	associatedData := decode("FEEDFACEDEADBEEFFEEDFACEDEADBEEFABADDAD2")
+	plaintext := decode("AAAAAAAAAAAAAAAABBBBBBBBBBBBBBBBCCCCCCCCCCCCCCCCDDDDDDDDDDDDDDDD" +
+		"EEEEEEEEEEEEEEEEFFFFFFFFFFFFFFFFEEEEEEEEEEEEEEEEAAAAAAAAAAAAAAAA")
+	ciphertext := base64.StdEncoding.EncodeToString([]byte(decode(
+		"17F399F08C67D5EE19D0DC9969C4BB7D5FD46FD3756489069157B282BB200735" +
+			"D82710CA5C22F0CCFA7CBF93D496AC15A56834CBCF98C397B4024A2691233B8D" +
+			"83DE3541E4C2B58177E065A9BF7B62EC")))
+
+	got, err := DecryptSM4ToString(key, associatedData, nonce, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptSM4ToString() error = %v", err)
+	}
+	if got != plaintext {
+		t.Errorf("DecryptSM4ToString() got = %x, want %x", got, plaintext)
+	}
+	if _, err = DecryptSM4ToString(key, "other", nonce, ciphertext); err == nil {
+		t.Errorf("DecryptSM4ToString() with wrong associated data should fail")
+	}
+}
@@ -0,0 +1,134 @@
+package wechatpaytest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+)
+
+// billDownloadPath 账单下载地址
+const billDownloadPath = "/v3/billdownload/file"
+
+// tradeBill 申请交易账单，账单包含 bill_date 当天支付成功的订单
+func (s *Server) tradeBill(w http.ResponseWriter, r *http.Request) {
+	date, err := time.ParseInLocation("2006-01-02", r.URL.Query().Get("bill_date"), time.Local)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "bill_date格式错误")
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString("交易时间,公众账号ID,商户号,微信订单号,商户订单号,用户标识,交易类型,交易状态,订单金额\r\n")
+	var count, total int
+	s.mu.Lock()
+	for _, o := range s.sortedOrders() {
+		if o.SuccessTime.IsZero() || !sameDay(o.SuccessTime, date) {
+			continue
+		}
+		count++
+		total += o.Amount.Total
+		fmt.Fprintf(&buf, "`%s,`%s,`%s,`%s,`%s,`%s,`%s,`%s,`%s\r\n", o.SuccessTime.Format("2006-01-02 15:04:05"),
+			o.AppID, o.MchID, o.TransactionID, o.OutTradeNo, o.Payer.OpenID, o.TradeType, o.TradeState, yuan(o.Amount.Total))
+	}
+	s.mu.Unlock()
+	buf.WriteString("总交易单数,总交易额\r\n")
+	fmt.Fprintf(&buf, "`%d,`%s\r\n", count, yuan(total))
+	s.replyBill(w, r, buf.String())
+}
+
+// fundflowBill 申请资金账单，账单包含 bill_date 当天的支付收入及退款支出
+func (s *Server) fundflowBill(w http.ResponseWriter, r *http.Request) {
+	date, err := time.ParseInLocation("2006-01-02", r.URL.Query().Get("bill_date"), time.Local)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "bill_date格式错误")
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString("记账时间,微信支付业务单号,业务名称,业务类型,收支类型,收支金额（元）\r\n")
+	var count int
+	s.mu.Lock()
+	for _, o := range s.sortedOrders() {
+		if !o.SuccessTime.IsZero() && sameDay(o.SuccessTime, date) {
+			count++
+			fmt.Fprintf(&buf, "`%s,`%s,`交易,`交易,`收入,`%s\r\n", o.SuccessTime.Format("2006-01-02 15:04:05"),
+				o.TransactionID, yuan(o.Amount.Total))
+		}
+	}
+	for _, rf := range s.refunds {
+		if rf.SuccessTime != nil && sameDay(*rf.SuccessTime, date) {
+			count++
+			fmt.Fprintf(&buf, "`%s,`%s,`退款,`退款,`支出,`%s\r\n", rf.SuccessTime.Format("2006-01-02 15:04:05"),
+				rf.RefundID, yuan(rf.Amount.Refund))
+		}
+	}
+	s.mu.Unlock()
+	buf.WriteString("资金流水总笔数\r\n")
+	fmt.Fprintf(&buf, "`%d\r\n", count)
+	s.replyBill(w, r, buf.String())
+}
+
+// replyBill 保存账单文件并返回下载地址，tar_type=GZIP 时下载的文件为gzip压缩
+func (s *Server) replyBill(w http.ResponseWriter, r *http.Request, content string) {
+	data := []byte(content)
+	hash := sha1.Sum(data)
+	tarType := r.URL.Query().Get("tar_type")
+	if tarType == "GZIP" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(data)
+		_ = zw.Close()
+		data = buf.Bytes()
+	}
+	token := randomString(32)
+	s.mu.Lock()
+	s.bills[token] = data
+	s.mu.Unlock()
+	s.reply(w, http.StatusOK, model.Bill{
+		DownloadURL: s.URL + billDownloadPath + "?token=" + token,
+		HashType:    "SHA1",
+		HashValue:   hex.EncodeToString(hash[:]),
+		TarType:     tarType,
+	})
+}
+
+// downloadBill 下载账单文件，回包没有签名
+func (s *Server) downloadBill(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.bills[r.URL.Query().Get("token")]
+	s.mu.Unlock()
+	if !ok {
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "账单文件不存在")
+		return
+	}
+	w.Header().Set(core.ContentType, "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+// sortedOrders 按商户订单号排序的订单，调用时需要持有锁
+func (s *Server) sortedOrders() []*order {
+	orders := make([]*order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OutTradeNo < orders[j].OutTradeNo })
+	return orders
+}
+
+func sameDay(t, date time.Time) bool {
+	y1, m1, d1 := t.In(time.Local).Date()
+	y2, m2, d2 := date.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// yuan 分转为元
+func yuan(fen int) string {
+	return fmt.Sprintf("%d.%02d", fen/100, fen%100)
+}
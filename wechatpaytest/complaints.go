@@ -0,0 +1,250 @@
+package wechatpaytest
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+	"github.com/perlyna/wechatpay/util"
+)
+
+// 投诉单状态
+const (
+	ComplaintStatePending    = "PENDING"    // 待处理
+	ComplaintStateProcessing = "PROCESSING" // 处理中
+	ComplaintStateProcessed  = "PROCESSED"  // 已处理完成
+)
+
+// complaint 模拟服务中的投诉单
+type complaint struct {
+	model.Complaint
+	Historys []model.NegotiationHistory // 协商历史
+}
+
+// AddComplaint 添加投诉单，未设置的投诉单号、投诉时间、被诉商户号及状态使用默认值，返回投诉单号
+//
+// PayerPhone 为明文，回包中使用请求签名的商户证书加密
+func (s *Server) AddComplaint(c model.Complaint) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addComplaint(c).ComplaintID
+}
+
+func (s *Server) addComplaint(c model.Complaint) *complaint {
+	if c.ComplaintID == "" {
+		c.ComplaintID = "2002" + s.nextID("")
+	}
+	if c.ComplaintTime.IsZero() {
+		c.ComplaintTime = time.Now()
+	}
+	if c.ComplaintedMchID == "" {
+		c.ComplaintedMchID = s.MchID
+	}
+	if c.ComplaintState == "" {
+		c.ComplaintState = ComplaintStatePending
+	}
+	if c.UserComplaintTimes == 0 {
+		c.UserComplaintTimes = 1
+	}
+	cp := &complaint{Complaint: c}
+	cp.addHistory("USER", "USER_CREATE_COMPLAINT", c.ComplaintDetail, nil)
+	s.complaints = append(s.complaints, cp)
+	return cp
+}
+
+func (c *complaint) addHistory(operator, operateType, details string, images []string) {
+	c.Historys = append(c.Historys, model.NegotiationHistory{
+		LogID:    c.ComplaintID + strconv.Itoa(len(c.Historys)+1),
+		Operator: operator,
+		Time:     time.Now().Format(time.RFC3339),
+		Type:     operateType,
+		Details:  details,
+		Images:   images,
+	})
+}
+
+// Complaint 查询投诉单当前状态
+func (s *Server) Complaint(complaintID string) (model.Complaint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.complaint(complaintID); c != nil {
+		return c.Complaint, true
+	}
+	return model.Complaint{}, false
+}
+
+// complaint 调用时需要持有锁
+func (s *Server) complaint(complaintID string) *complaint {
+	for _, c := range s.complaints {
+		if c.ComplaintID == complaintID {
+			return c
+		}
+	}
+	return nil
+}
+
+// ComplaintNotifyURL 商户设置的投诉通知回调地址
+func (s *Server) ComplaintNotifyURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.complaintNotifyURL
+}
+
+// routeComplaints 投诉单列表、详情、协商历史、回复及处理完成
+func (s *Server) routeComplaints(w http.ResponseWriter, r *http.Request, body []byte, merchantCertificate *x509.Certificate) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v3/merchant-service/complaints-v2"), "/")
+	if path == "" && r.Method == http.MethodGet {
+		s.listComplaints(w, r, merchantCertificate)
+		return
+	}
+	parts := strings.Split(path, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.complaint(parts[0])
+	if c == nil {
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "投诉单不存在")
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.replyComplaint(w, c.Complaint, merchantCertificate)
+	case len(parts) == 2 && parts[1] == "negotiation-historys" && r.Method == http.MethodGet:
+		offset, limit := page(r)
+		historys := c.Historys[min(offset, len(c.Historys)):min(offset+limit, len(c.Historys))]
+		s.reply(w, http.StatusOK, model.NegotiationHistoryReply{Historys: historys, Offset: offset, Limit: limit,
+			TotalCount: len(c.Historys)})
+	case len(parts) == 2 && parts[1] == "response" && r.Method == http.MethodPost:
+		req := model.ComplaintResponse{}
+		if err := json.Unmarshal(body, &req); err != nil || req.MchID != s.MchID || req.Content == "" {
+			s.fail(w, http.StatusBadRequest, core.CodeParamError, "回复内容或被诉商户号错误")
+			return
+		}
+		if c.ComplaintState == ComplaintStatePending {
+			c.ComplaintState = ComplaintStateProcessing
+		}
+		c.IncomingUserResponse = false
+		c.addHistory("MERCHANT", "MERCHANT_RESPONSE", req.Content, req.ResponseImages)
+		s.reply(w, http.StatusNoContent, nil)
+	case len(parts) == 2 && parts[1] == "complete" && r.Method == http.MethodPost:
+		if c.ComplaintState == ComplaintStatePending {
+			s.fail(w, http.StatusBadRequest, core.CodeInvalidRequest, "投诉单未回复，不能处理完成")
+			return
+		}
+		c.ComplaintState = ComplaintStateProcessed
+		c.addHistory("MERCHANT", "MERCHANT_CONFIRM_COMPLETE", "", nil)
+		s.reply(w, http.StatusNoContent, nil)
+	default:
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "接口不存在")
+	}
+}
+
+// listComplaints 查询 begin_date 到 end_date 之间的投诉单
+func (s *Server) listComplaints(w http.ResponseWriter, r *http.Request, merchantCertificate *x509.Certificate) {
+	begin, err1 := time.ParseInLocation("2006-01-02", r.URL.Query().Get("begin_date"), time.Local)
+	end, err2 := time.ParseInLocation("2006-01-02", r.URL.Query().Get("end_date"), time.Local)
+	if err1 != nil || err2 != nil {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "begin_date或end_date格式错误")
+		return
+	}
+	end = end.AddDate(0, 0, 1)
+	offset, limit := page(r)
+	s.mu.Lock()
+	var matched []model.Complaint
+	for _, c := range s.complaints {
+		if !c.ComplaintTime.Before(begin) && c.ComplaintTime.Before(end) {
+			matched = append(matched, c.Complaint)
+		}
+	}
+	s.mu.Unlock()
+	reply := model.ComplaintReply{Offset: offset, Limit: limit, TotalCount: len(matched),
+		Complaints: []model.Complaint{}}
+	for _, c := range matched[min(offset, len(matched)):min(offset+limit, len(matched))] {
+		encrypted, err := encryptPayerPhone(c, merchantCertificate)
+		if err != nil {
+			s.fail(w, http.StatusInternalServerError, core.CodeSystemError, err.Error())
+			return
+		}
+		reply.Complaints = append(reply.Complaints, encrypted)
+	}
+	s.reply(w, http.StatusOK, reply)
+}
+
+// replyComplaint 返回加密了投诉人联系方式的投诉单
+func (s *Server) replyComplaint(w http.ResponseWriter, c model.Complaint, merchantCertificate *x509.Certificate) {
+	encrypted, err := encryptPayerPhone(c, merchantCertificate)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, core.CodeSystemError, err.Error())
+		return
+	}
+	s.reply(w, http.StatusOK, encrypted)
+}
+
+// encryptPayerPhone 使用商户证书加密投诉人联系方式
+func encryptPayerPhone(c model.Complaint, merchantCertificate *x509.Certificate) (model.Complaint, error) {
+	if c.PayerPhone == "" {
+		return c, nil
+	}
+	ciphertext, err := util.EncryptOAEPWithCertificate(c.PayerPhone, merchantCertificate)
+	if err != nil {
+		return c, err
+	}
+	c.PayerPhone = ciphertext
+	return c, nil
+}
+
+// complaintNotification 创建、查询、更新及删除投诉通知回调地址
+func (s *Server) complaintNotification(w http.ResponseWriter, r *http.Request, body []byte) {
+	req := struct {
+		MchID string `json:"mchid,omitempty"`
+		URL   string `json:"url,omitempty"`
+	}{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if s.complaintNotifyURL == "" {
+			s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "投诉通知回调地址不存在")
+			return
+		}
+	case http.MethodPost, http.MethodPut:
+		if err := json.Unmarshal(body, &req); err != nil || !strings.HasPrefix(req.URL, "http") {
+			s.fail(w, http.StatusBadRequest, core.CodeParamError, "回调地址错误")
+			return
+		}
+		s.complaintNotifyURL = req.URL
+	case http.MethodDelete:
+		s.complaintNotifyURL = ""
+		s.reply(w, http.StatusNoContent, nil)
+		return
+	default:
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "接口不存在")
+		return
+	}
+	req.MchID, req.URL = s.MchID, s.complaintNotifyURL
+	s.reply(w, http.StatusOK, req)
+}
+
+// page 分页参数，limit 默认为10
+func page(r *http.Request) (offset, limit int) {
+	offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	return offset, limit
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,229 @@
+package wechatpaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+	"github.com/perlyna/wechatpay/util"
+)
+
+// Delivery 一次回调通知的发送记录
+type Delivery struct {
+	URL        string    // 通知地址
+	ID         string    // 通知ID
+	EventType  string    // 通知类型
+	Body       []byte    // 通知内容
+	SentAt     time.Time // 发送时间
+	StatusCode int       // 商户应答的http状态码，发送失败时为0
+	Err        error     // 发送失败或商户应答非2xx时的错误
+}
+
+// PayOrder 模拟用户支付订单，订单变为 SUCCESS 后向支付通知地址发送 TRANSACTION.SUCCESS 通知
+//
+// 订单没有通知地址时使用 Server.NotifyURL，返回通知发送的错误
+func (s *Server) PayOrder(outTradeNo string) error {
+	s.mu.Lock()
+	o, ok := s.orders[outTradeNo]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("wechatpaytest: order %s not exist", outTradeNo)
+	}
+	if o.TradeState != TradeStateNotPay {
+		s.mu.Unlock()
+		return fmt.Errorf("wechatpaytest: order %s is %s", outTradeNo, o.TradeState)
+	}
+	o.TradeState, o.TradeStateDesc = TradeStateSuccess, "支付成功"
+	o.TransactionID = s.nextID("42")
+	o.SuccessTime = time.Now()
+	o.BankType = "OTHERS"
+	o.Amount.PayerTotal = o.Amount.Total
+	o.Amount.PayerCurrency = o.Amount.Currency
+	s.transactions[o.TransactionID] = o.OutTradeNo
+	tradeQuery, notifyURL := o.TradeQuery, s.notifyURL(o.NotifyURL)
+	s.mu.Unlock()
+	return s.notify(notifyURL, model.EventTransactionSuccess, "支付成功", "transaction", tradeQuery)
+}
+
+// SettleRefund 在 after 之后把处理中的退款变为 status（SUCCESS、ABNORMAL 或 CLOSED），并发送 REFUND.<status> 通知
+//
+// after 为0时立即处理并返回通知发送的错误；否则在后台处理，结果可以通过 Deliveries 查看
+func (s *Server) SettleRefund(outRefundNo, status string, after time.Duration) error {
+	if status != RefundStatusSuccess && status != RefundStatusAbnormal && status != RefundStatusClosed {
+		return fmt.Errorf("wechatpaytest: invalid refund status %s", status)
+	}
+	s.mu.Lock()
+	_, ok := s.refunds[outRefundNo]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("wechatpaytest: refund %s not exist", outRefundNo)
+	}
+	if after <= 0 {
+		return s.settleRefund(outRefundNo, status)
+	}
+	time.AfterFunc(after, func() { _ = s.settleRefund(outRefundNo, status) })
+	return nil
+}
+
+func (s *Server) settleRefund(outRefundNo, status string) error {
+	s.mu.Lock()
+	rf := s.refunds[outRefundNo]
+	if rf.Status != RefundStatusProcessing {
+		s.mu.Unlock()
+		return fmt.Errorf("wechatpaytest: refund %s is %s", outRefundNo, rf.Status)
+	}
+	rf.Status = status
+	if status == RefundStatusSuccess {
+		now := time.Now()
+		rf.SuccessTime = &now
+	} else if o, ok := s.orders[rf.OutTradeNo]; ok {
+		// 退款失败后可以重新申请
+		o.Refunded -= rf.Amount.Refund
+	}
+	resource := model.RefundNotify{
+		MchID:               s.MchID,
+		OutTradeNo:          rf.OutTradeNo,
+		TransactionID:       rf.TransactionID,
+		OutRefundNo:         rf.OutRefundNo,
+		RefundID:            rf.RefundID,
+		RefundStatus:        rf.Status,
+		SuccessTime:         rf.SuccessTime,
+		UserReceivedAccount: rf.UserReceivedAccount,
+	}
+	resource.Amount.Total = rf.Amount.Total
+	resource.Amount.Refund = rf.Amount.Refund
+	resource.Amount.PayerTotal = rf.Amount.PayerTotal
+	resource.Amount.PayerRefund = rf.Amount.PayerRefund
+	notifyURL := s.notifyURL(rf.NotifyURL)
+	s.mu.Unlock()
+	summaries := map[string]string{RefundStatusSuccess: "退款成功", RefundStatusAbnormal: "退款异常", RefundStatusClosed: "退款关闭"}
+	return s.notify(notifyURL, "REFUND."+status, summaries[status], "refund", resource)
+}
+
+// CreateComplaint 模拟用户发起投诉，添加投诉单后向投诉通知回调地址发送 COMPLAINT.CREATE 通知，返回投诉单号
+//
+// 商户没有设置投诉通知回调地址时只添加投诉单
+func (s *Server) CreateComplaint(c model.Complaint) (string, error) {
+	s.mu.Lock()
+	cp := s.addComplaint(c)
+	notifyURL := s.complaintNotifyURL
+	s.mu.Unlock()
+	if notifyURL == "" {
+		return cp.ComplaintID, nil
+	}
+	resource := map[string]string{"complaint_id": cp.ComplaintID, "action_type": "CREATE_COMPLAINT"}
+	return cp.ComplaintID, s.notify(notifyURL, model.EventComplaintCreate, "产生新投诉", "complaint", resource)
+}
+
+// Deliveries 已发送的回调通知，按发送顺序排列
+func (s *Server) Deliveries() []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Delivery(nil), s.deliveries...)
+}
+
+// Redeliver 重新发送通知ID对应的通知，模拟微信支付的重试，请求内容相同但签名的时间戳和随机串不同
+func (s *Server) Redeliver(id string) error {
+	s.mu.Lock()
+	var found *Delivery
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == id {
+			found = &s.deliveries[i]
+		}
+	}
+	s.mu.Unlock()
+	if found == nil {
+		return fmt.Errorf("wechatpaytest: notification %s not exist", id)
+	}
+	return s.deliver(found.URL, found.ID, found.EventType, found.Body)
+}
+
+// notifyURL 通知地址为空时使用 Server.NotifyURL，调用时需要持有锁
+func (s *Server) notifyURL(notifyURL string) string {
+	if notifyURL == "" {
+		return s.NotifyURL
+	}
+	return notifyURL
+}
+
+// notify 加密资源数据并发送回调通知
+func (s *Server) notify(notifyURL, eventType, summary, originalType string, resource interface{}) error {
+	if notifyURL == "" {
+		return fmt.Errorf("wechatpaytest: no notify url for %s", eventType)
+	}
+	plaintext, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	nonce := randomString(12)
+	ciphertext, err := util.EncryptToString(s.APIv3Key, originalType, nonce, string(plaintext))
+	if err != nil {
+		return err
+	}
+	notification := model.Notification{
+		ID:           randomUUID(),
+		CreateTime:   time.Now().Truncate(time.Second),
+		EventType:    eventType,
+		ResourceType: "encrypt-resource",
+		Summary:      summary,
+		Resource: model.NotificationResource{
+			Algorithm:      util.AlgorithmAESGCM,
+			Ciphertext:     ciphertext,
+			OriginalType:   originalType,
+			AssociatedData: originalType,
+			Nonce:          nonce,
+		},
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return s.deliver(notifyURL, notification.ID, eventType, body)
+}
+
+// deliver 签名并发送通知，记录发送结果
+func (s *Server) deliver(notifyURL, id, eventType string, body []byte) error {
+	delivery := Delivery{URL: notifyURL, ID: id, EventType: eventType, Body: body, SentAt: time.Now()}
+	delivery.StatusCode, delivery.Err = s.post(notifyURL, body)
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, delivery)
+	s.mu.Unlock()
+	return delivery.Err
+}
+
+func (s *Server) post(notifyURL string, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, notifyURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set(core.ContentType, core.ApplicationJSON)
+	request.Header.Set(core.UserAgent, "Mozilla/4.0")
+	if err = s.Sign(request.Header, body); err != nil {
+		return 0, err
+	}
+	hc := s.NotifyClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	response, err := hc.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	slurp, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("wechatpaytest: notify %s status %d: %s", notifyURL, response.StatusCode, slurp)
+	}
+	return response.StatusCode, nil
+}
+
+// randomUUID 生成通知ID
+func randomUUID() string {
+	s := randomString(32)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[:8], s[8:12], s[12:16], s[16:20], s[20:])
+}
@@ -0,0 +1,97 @@
+package wechatpaytest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+)
+
+func TestServerNotifications(t *testing.T) {
+	server := NewServer("1900009191")
+	defer server.Close()
+	pay := server.NewWechatPay()
+	ctx := context.Background()
+
+	events := make(chan string, 10)
+	mux := pay.NewNotifyMux()
+	mux.Store = core.NewMemoryNotificationStore(0)
+	mux.HandleTransaction(model.EventTransactionSuccess,
+		func(ctx context.Context, notification *model.Notification, transaction *model.TradeQuery) error {
+			events <- notification.EventType + " " + transaction.OutTradeNo + " " + transaction.TradeState
+			return nil
+		})
+	mux.HandleRefund("REFUND.", func(ctx context.Context, notification *model.Notification, refund *model.RefundNotify) error {
+		events <- notification.EventType + " " + refund.OutRefundNo + " " + refund.RefundStatus
+		return nil
+	})
+	mux.HandleComplaint(model.EventComplaintCreate, func(ctx context.Context, event *model.ComplaintEvent) error {
+		events <- event.EventType + " " + event.ComplaintID
+		return nil
+	})
+	app := httptest.NewServer(mux)
+	defer app.Close()
+	server.NotifyURL = app.URL
+
+	server.AddOrder(model.TradeQuery{OutTradeNo: "T1", Amount: model.Amount{Total: 100}})
+	if err := server.PayOrder("T1"); err != nil {
+		t.Fatalf("PayOrder() error = %v", err)
+	}
+	if got := <-events; got != "TRANSACTION.SUCCESS T1 SUCCESS" {
+		t.Errorf("event = %s", got)
+	}
+	if err := server.PayOrder("T1"); err == nil {
+		t.Errorf("PayOrder() paid order should fail")
+	}
+
+	// 重试的通知内容相同，应答成功但不会重复处理
+	deliveries := server.Deliveries()
+	if err := server.Redeliver(deliveries[0].ID); err != nil {
+		t.Fatalf("Redeliver() error = %v", err)
+	}
+	if len(server.Deliveries()) != 2 || len(events) != 0 {
+		t.Errorf("deliveries = %d, events = %d", len(server.Deliveries()), len(events))
+	}
+
+	refund, err := pay.RefundByOutTradeNo(ctx, "T1", 30)
+	if err != nil {
+		t.Fatalf("RefundByOutTradeNo() error = %v", err)
+	}
+	if err = server.SettleRefund(refund.OutRefundNo, RefundStatusAbnormal, 0); err != nil {
+		t.Fatalf("SettleRefund() error = %v", err)
+	}
+	if got := <-events; got != "REFUND.ABNORMAL "+refund.OutRefundNo+" ABNORMAL" {
+		t.Errorf("event = %s", got)
+	}
+
+	// 退款异常后可以重新申请，延迟退款成功
+	refund2, err := pay.RefundByOutTradeNo(ctx, "T1", 100)
+	if err != nil {
+		t.Fatalf("RefundByOutTradeNo() error = %v", err)
+	}
+	if err = server.SettleRefund(refund2.OutRefundNo, RefundStatusSuccess, 10*time.Millisecond); err != nil {
+		t.Fatalf("SettleRefund() error = %v", err)
+	}
+	select {
+	case got := <-events:
+		if got != "REFUND.SUCCESS "+refund2.OutRefundNo+" SUCCESS" {
+			t.Errorf("event = %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refund notification timeout")
+	}
+
+	if err = pay.CreateComplaintNotification(ctx, app.URL); err != nil {
+		t.Fatal(err)
+	}
+	id, err := server.CreateComplaint(model.Complaint{ComplaintDetail: "未收到商品"})
+	if err != nil {
+		t.Fatalf("CreateComplaint() error = %v", err)
+	}
+	if got := <-events; got != "COMPLAINT.CREATE "+id {
+		t.Errorf("event = %s", got)
+	}
+}
@@ -0,0 +1,295 @@
+package wechatpaytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/model"
+)
+
+// 交易状态
+const (
+	TradeStateSuccess = "SUCCESS" // 支付成功
+	TradeStateRefund  = "REFUND"  // 转入退款
+	TradeStateNotPay  = "NOTPAY"  // 未支付
+	TradeStateClosed  = "CLOSED"  // 已关闭
+)
+
+// 退款状态
+const (
+	RefundStatusSuccess    = "SUCCESS"    // 退款成功
+	RefundStatusClosed     = "CLOSED"     // 退款关闭
+	RefundStatusProcessing = "PROCESSING" // 退款处理中
+	RefundStatusAbnormal   = "ABNORMAL"   // 退款异常
+)
+
+// order 模拟服务中的订单
+type order struct {
+	model.TradeQuery
+	Description string // 商品描述
+	NotifyURL   string // 支付通知地址
+	PrepayID    string // 预支付交易会话标识
+	Refunded    int    // 已申请退款的金额
+}
+
+// refund 模拟服务中的退款
+type refund struct {
+	model.RefundsOrder
+	NotifyURL string // 退款通知地址
+}
+
+// tradeTypes 下单接口路径对应的交易类型
+var tradeTypes = map[string]string{
+	"jsapi":  "JSAPI",
+	"app":    "APP",
+	"native": "NATIVE",
+	"h5":     "MWEB",
+}
+
+// AddOrder 添加订单，未设置的商户号、微信支付订单号、交易状态、用户支付金额及币种使用默认值
+func (s *Server) AddOrder(tradeQuery model.TradeQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tradeQuery.MchID == "" {
+		tradeQuery.MchID = s.MchID
+	}
+	if tradeQuery.TradeState == "" {
+		tradeQuery.TradeState = TradeStateNotPay
+	}
+	if tradeQuery.TransactionID == "" && tradeQuery.TradeState != TradeStateNotPay {
+		tradeQuery.TransactionID = s.nextID("42")
+	}
+	if tradeQuery.Amount.Currency == "" {
+		tradeQuery.Amount.Currency = "CNY"
+	}
+	if tradeQuery.TradeState == TradeStateSuccess && tradeQuery.Amount.PayerTotal == 0 {
+		tradeQuery.Amount.PayerTotal = tradeQuery.Amount.Total
+		tradeQuery.Amount.PayerCurrency = tradeQuery.Amount.Currency
+	}
+	s.addOrder(&order{TradeQuery: tradeQuery})
+}
+
+func (s *Server) addOrder(o *order) {
+	s.orders[o.OutTradeNo] = o
+	if o.TransactionID != "" {
+		s.transactions[o.TransactionID] = o.OutTradeNo
+	}
+}
+
+// Order 查询订单当前状态
+func (s *Server) Order(outTradeNo string) (model.TradeQuery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[outTradeNo]
+	if !ok {
+		return model.TradeQuery{}, false
+	}
+	return o.TradeQuery, true
+}
+
+// Refund 查询退款当前状态
+func (s *Server) Refund(outRefundNo string) (model.RefundsOrder, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.refunds[outRefundNo]
+	if !ok {
+		return model.RefundsOrder{}, false
+	}
+	return r.RefundsOrder, true
+}
+
+// routeTransactions 下单、查询订单及关闭订单
+func (s *Server) routeTransactions(w http.ResponseWriter, r *http.Request, body []byte) {
+	path := strings.TrimPrefix(r.URL.Path, "/v3/pay/transactions/")
+	switch {
+	case r.Method == http.MethodPost && tradeTypes[path] != "":
+		s.createOrder(w, tradeTypes[path], path, body)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "id/"):
+		s.mu.Lock()
+		outTradeNo := s.transactions[strings.TrimPrefix(path, "id/")]
+		s.mu.Unlock()
+		s.queryOrder(w, r, outTradeNo)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "out-trade-no/") && strings.HasSuffix(path, "/close"):
+		s.closeOrder(w, strings.TrimSuffix(strings.TrimPrefix(path, "out-trade-no/"), "/close"), body)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "out-trade-no/"):
+		s.queryOrder(w, r, strings.TrimPrefix(path, "out-trade-no/"))
+	default:
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "接口不存在")
+	}
+}
+
+// createOrder 下单，订单状态为 NOTPAY
+func (s *Server) createOrder(w http.ResponseWriter, tradeType, kind string, body []byte) {
+	req := model.UnifiedOrder{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "请求内容格式错误")
+		return
+	}
+	switch {
+	case req.AppID == "" || req.Description == "" || req.OutTradeNo == "" || req.NotifyURL == "" || req.Amount.Total <= 0:
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "缺少必填参数")
+		return
+	case req.MchID != s.MchID:
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "mchid与请求的商户号不一致")
+		return
+	case tradeType == "JSAPI" && (req.Payer == nil || req.Payer.OpenID == ""):
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "JSAPI下单缺少payer.openid")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[req.OutTradeNo]; ok {
+		s.fail(w, http.StatusBadRequest, core.CodeInvalidRequest, "商户订单号重复")
+		return
+	}
+	o := &order{
+		TradeQuery: model.TradeQuery{
+			AppID:          req.AppID,
+			MchID:          req.MchID,
+			OutTradeNo:     req.OutTradeNo,
+			TradeType:      tradeType,
+			TradeState:     TradeStateNotPay,
+			TradeStateDesc: "订单未支付",
+			Attach:         req.Attach,
+			Amount:         req.Amount,
+			SceneInfo:      req.SceneInfo,
+		},
+		Description: req.Description,
+		NotifyURL:   req.NotifyURL,
+		PrepayID:    "wx" + s.nextID(""),
+	}
+	if o.Amount.Currency == "" {
+		o.Amount.Currency = "CNY"
+	}
+	if req.Payer != nil {
+		o.Payer = *req.Payer
+	}
+	s.addOrder(o)
+	switch kind {
+	case "native":
+		s.reply(w, http.StatusOK, map[string]string{"code_url": "weixin://wxpay/bizpayurl?pr=" + randomString(7)})
+	case "h5":
+		s.reply(w, http.StatusOK, map[string]string{"h5_url": "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=" + o.PrepayID})
+	default:
+		s.reply(w, http.StatusOK, map[string]string{"prepay_id": o.PrepayID})
+	}
+}
+
+// queryOrder 查询订单
+func (s *Server) queryOrder(w http.ResponseWriter, r *http.Request, outTradeNo string) {
+	if r.URL.Query().Get("mchid") != s.MchID {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "mchid与请求的商户号不一致")
+		return
+	}
+	s.mu.Lock()
+	o, ok := s.orders[outTradeNo]
+	var tradeQuery model.TradeQuery
+	if ok {
+		tradeQuery = o.TradeQuery
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.fail(w, http.StatusNotFound, core.CodeOrderNotExist, "订单不存在")
+		return
+	}
+	s.reply(w, http.StatusOK, tradeQuery)
+}
+
+// closeOrder 关闭未支付的订单
+func (s *Server) closeOrder(w http.ResponseWriter, outTradeNo string, body []byte) {
+	req := struct {
+		MchID string `json:"mchid"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil || req.MchID != s.MchID {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "mchid与请求的商户号不一致")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[outTradeNo]
+	switch {
+	case !ok:
+		s.fail(w, http.StatusNotFound, core.CodeOrderNotExist, "订单不存在")
+	case o.TradeState != TradeStateNotPay && o.TradeState != TradeStateClosed:
+		s.fail(w, http.StatusBadRequest, core.CodeInvalidRequest, "订单已支付")
+	default:
+		o.TradeState, o.TradeStateDesc = TradeStateClosed, "订单已关闭"
+		s.reply(w, http.StatusNoContent, nil)
+	}
+}
+
+// createRefund 申请退款，相同商户退款单号重复申请时返回已有的退款
+func (s *Server) createRefund(w http.ResponseWriter, body []byte) {
+	req := model.RefundsReq{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "请求内容格式错误")
+		return
+	}
+	if req.OutRefundNo == "" || (req.TransactionID == "" && req.OutTradeNo == "") || req.Amount.Refund <= 0 {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "缺少必填参数")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.refunds[req.OutRefundNo]; ok {
+		s.reply(w, http.StatusOK, existing.RefundsOrder)
+		return
+	}
+	outTradeNo := req.OutTradeNo
+	if req.TransactionID != "" {
+		outTradeNo = s.transactions[req.TransactionID]
+	}
+	o, ok := s.orders[outTradeNo]
+	switch {
+	case !ok:
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "订单不存在")
+		return
+	case o.TradeState != TradeStateSuccess && o.TradeState != TradeStateRefund:
+		s.fail(w, http.StatusForbidden, core.CodeInvalidRequest, "订单未支付，不能退款")
+		return
+	case req.Amount.Total != o.Amount.Total:
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "订单金额与原订单不一致")
+		return
+	case o.Refunded+req.Amount.Refund > o.Amount.Total:
+		s.fail(w, http.StatusForbidden, core.CodeNotEnough, "退款金额超过订单可退金额")
+		return
+	}
+	rf := &refund{
+		RefundsOrder: model.RefundsOrder{
+			RefundID:            "50" + s.nextID(""),
+			OutRefundNo:         req.OutRefundNo,
+			TransactionID:       o.TransactionID,
+			OutTradeNo:          o.OutTradeNo,
+			Channel:             "ORIGINAL",
+			UserReceivedAccount: "支付用户零钱",
+			CreateTime:          time.Now(),
+			Status:              RefundStatusProcessing,
+			FundsAccount:        req.FundsAccount,
+			Amount: model.RefundsAmount{
+				Refund:      req.Amount.Refund,
+				Total:       o.Amount.Total,
+				Currency:    o.Amount.Currency,
+				PayerTotal:  o.Amount.PayerTotal,
+				PayerRefund: req.Amount.Refund,
+			},
+		},
+		NotifyURL: req.NotifyURL,
+	}
+	s.refunds[req.OutRefundNo] = rf
+	o.Refunded += req.Amount.Refund
+	o.TradeState, o.TradeStateDesc = TradeStateRefund, "转入退款"
+	s.reply(w, http.StatusOK, rf.RefundsOrder)
+}
+
+// queryRefund 查询退款
+func (s *Server) queryRefund(w http.ResponseWriter, outRefundNo string) {
+	refund, ok := s.Refund(outRefundNo)
+	if !ok {
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "退款单不存在")
+		return
+	}
+	s.reply(w, http.StatusOK, refund)
+}
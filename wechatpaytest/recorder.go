@@ -0,0 +1,258 @@
+package wechatpaytest
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/perlyna/wechatpay"
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/util"
+)
+
+// Interaction 录制的一次请求及回包
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest 录制的请求，Authorization 已脱敏
+type RecordedRequest struct {
+	Method     string      `json:"method"`                // 请求方法
+	URL        string      `json:"url"`                   // 请求地址
+	Header     http.Header `json:"header,omitempty"`      // 请求header
+	Body       string      `json:"body,omitempty"`        // 请求内容
+	BodyBase64 []byte      `json:"body_base64,omitempty"` // 非UTF-8的请求内容
+}
+
+// RecordedResponse 录制的回包，Wechatpay-* 签名header已去除，回放时重新签名
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`           // http状态码
+	Header     http.Header `json:"header,omitempty"`      // 回包header
+	Body       string      `json:"body,omitempty"`        // 回包内容
+	BodyBase64 []byte      `json:"body_base64,omitempty"` // 非UTF-8的回包内容，如gzip压缩的账单
+	Signed     bool        `json:"signed,omitempty"`      // 原回包是否带有签名
+}
+
+// scrubbedHeaders 录制时从请求及回包中去除的header
+var scrubbedHeaders = []string{core.Authorization, "Cookie", "Set-Cookie"}
+
+// signatureHeaders 回包签名header，回放时使用测试平台证书重新生成
+var signatureHeaders = []string{core.WechatPaySerial, core.WechatPayTimestamp, core.WechatPayNonce, core.WechatPaySignature}
+
+// Recorder 录制及回放微信支付请求的 http.RoundTripper，用于可重复的SDK集成回归测试
+//
+// 录制模式下请求通过 Transport 发送到真实服务，脱敏后的请求及回包在 Save 时写入 golden 文件；
+// 回放模式下按顺序返回文件中的回包，并使用生成的测试平台证书以当前时间重新签名，
+// 因此通过 Configure 添加了测试平台证书的 WechatPay 可以正常验签：
+//
+//	recorder, err := wechatpaytest.NewRecorder("testdata/refund.json", *record)
+//	recorder.Configure(pay)
+//	defer recorder.Save()
+//
+// 回放只比较请求方法及路径，请求签名不再校验
+type Recorder struct {
+	Transport           http.RoundTripper  // 录制时发送请求使用，为空时使用 http.DefaultTransport
+	Scrub               func(*Interaction) // 写入文件前额外脱敏，如去除回包中的用户标识
+	PlatformPrivateKey  *rsa.PrivateKey    // 回放签名使用的测试平台私钥
+	PlatformCertificate *x509.Certificate  // 回放签名使用的测试平台证书
+	PlatformSerialNo    string             // 测试平台证书序列号
+
+	path      string
+	recording bool
+	signer    *core.SHA256WithRSASigner
+
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+}
+
+// NewRecorder 创建录制器，record 为 true 时录制到 path，否则从 path 读取录制的请求及回包并回放
+func NewRecorder(path string, record bool) (*Recorder, error) {
+	platformKey, platformCertificate := fixtures.NewCertificate("Tenpay.com Root CA")
+	r := &Recorder{
+		PlatformPrivateKey:  platformKey,
+		PlatformCertificate: platformCertificate,
+		PlatformSerialNo:    util.GetCertificateSerialNumber(platformCertificate),
+		path:                path,
+		recording:           record,
+	}
+	r.signer = &core.SHA256WithRSASigner{MchCertificateSerialNo: r.PlatformSerialNo, PrivateKey: platformKey}
+	if record {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("wechatpaytest: read recording: %w", err)
+	}
+	if err = json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, fmt.Errorf("wechatpaytest: parse recording %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Recording 是否为录制模式
+func (r *Recorder) Recording() bool {
+	return r.recording
+}
+
+// Configure 让 WechatPay 通过录制器发送请求，回放模式下同时添加测试平台证书
+func (r *Recorder) Configure(p *wechatpay.WechatPay) {
+	client := &http.Client{}
+	if p.Client != nil {
+		*client = *p.Client
+	}
+	if r.Transport == nil {
+		r.Transport = client.Transport
+	}
+	client.Transport = r
+	p.Client = client
+	if !r.recording {
+		p.AddPlatformCertificates(r.PlatformCertificate)
+	}
+}
+
+// RoundTrip 录制或回放一次请求
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body []byte
+	if request.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(request.Body); err != nil {
+			return nil, err
+		}
+		_ = request.Body.Close()
+	}
+	if r.recording {
+		return r.record(request, body)
+	}
+	return r.replay(request)
+}
+
+func (r *Recorder) record(request *http.Request, body []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	outgoing := request.Clone(request.Context())
+	outgoing.Body = ioutil.NopCloser(bytes.NewReader(body))
+	response, err := transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	interaction := Interaction{
+		Request: RecordedRequest{Method: request.Method, URL: request.URL.String(), Header: request.Header.Clone()},
+		Response: RecordedResponse{StatusCode: response.StatusCode, Header: response.Header.Clone(),
+			Signed: response.Header.Get(core.WechatPaySignature) != ""},
+	}
+	interaction.Request.Body, interaction.Request.BodyBase64 = encodeBody(body)
+	interaction.Response.Body, interaction.Response.BodyBase64 = encodeBody(responseBody)
+	for _, key := range scrubbedHeaders {
+		interaction.Request.Header.Del(key)
+		interaction.Response.Header.Del(key)
+	}
+	for _, key := range signatureHeaders {
+		interaction.Response.Header.Del(key)
+	}
+	if r.Scrub != nil {
+		r.Scrub(&interaction)
+	}
+	r.mu.Lock()
+	r.interactions = append(r.interactions, interaction)
+	r.mu.Unlock()
+	response.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+	return response, nil
+}
+
+func (r *Recorder) replay(request *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	if r.next >= len(r.interactions) {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("wechatpaytest: unexpected request %s %s, recording %s has %d requests",
+			request.Method, request.URL.Path, r.path, len(r.interactions))
+	}
+	interaction := r.interactions[r.next]
+	r.next++
+	r.mu.Unlock()
+	recorded, err := request.URL.Parse(interaction.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Request.Method != request.Method || recorded.Path != request.URL.Path {
+		return nil, fmt.Errorf("wechatpaytest: request %s %s does not match recorded %s %s",
+			request.Method, request.URL.Path, interaction.Request.Method, recorded.Path)
+	}
+	body := decodeBody(interaction.Response.Body, interaction.Response.BodyBase64)
+	header := interaction.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if interaction.Response.Signed {
+		if err = signHeader(r.signer, header, body); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}, nil
+}
+
+// Remaining 回放模式下尚未使用的录制请求数
+func (r *Recorder) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.interactions) - r.next
+}
+
+// Save 录制模式下把录制的请求及回包写入文件，回放模式下不做任何操作
+func (r *Recorder) Save() error {
+	if !r.recording {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+}
+
+// encodeBody UTF-8内容保存为字符串，其余保存为base64
+func encodeBody(body []byte) (string, []byte) {
+	if utf8.Valid(body) {
+		return string(body), nil
+	}
+	return "", body
+}
+
+func decodeBody(body string, bodyBase64 []byte) []byte {
+	if bodyBase64 != nil {
+		return bodyBase64
+	}
+	return []byte(body)
+}
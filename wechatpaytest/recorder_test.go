@@ -0,0 +1,63 @@
+package wechatpaytest
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perlyna/wechatpay"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/model"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "order.json")
+	ctx := context.Background()
+
+	server := NewServer("1900009191")
+	server.AddOrder(model.TradeQuery{OutTradeNo: "T1", TradeState: TradeStateSuccess, Amount: model.Amount{Total: 100},
+		Payer: model.Payer{OpenID: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"}})
+	pay := server.NewWechatPay()
+	recorder, err := NewRecorder(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Scrub = func(interaction *Interaction) {
+		interaction.Response.Body = strings.Replace(interaction.Response.Body, "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o", "OPENID", -1)
+	}
+	recorder.Configure(pay)
+	recorded, err := pay.OrderQueryByOutTradeNo(ctx, "T1")
+	if err != nil {
+		t.Fatalf("OrderQueryByOutTradeNo() record error = %v", err)
+	}
+	if err = recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "WECHATPAY2-SHA256-RSA2048") || strings.Contains(string(data), "oUpF8uMuAJO") {
+		t.Errorf("recording not scrubbed: %s", data)
+	}
+
+	// 回放时使用其他商户密钥，服务已关闭，回包使用测试平台证书重新签名
+	key, certificate := fixtures.NewCertificate("1900009191")
+	pay = wechatpay.New("1900009191", "0123456789abcdef0123456789abcdef", key, certificate)
+	pay.SetBaseURL(server.URL, "")
+	if recorder, err = NewRecorder(path, false); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Configure(pay)
+	replayed, err := pay.OrderQueryByOutTradeNo(ctx, "T1")
+	if err != nil {
+		t.Fatalf("OrderQueryByOutTradeNo() replay error = %v", err)
+	}
+	if replayed.TransactionID != recorded.TransactionID || replayed.Payer.OpenID != "OPENID" || recorder.Remaining() != 0 {
+		t.Errorf("replayed = %+v, remaining = %d", replayed, recorder.Remaining())
+	}
+	if _, err = pay.OrderQueryByOutTradeNo(ctx, "T1"); err == nil {
+		t.Errorf("OrderQueryByOutTradeNo() beyond recording should fail")
+	}
+}
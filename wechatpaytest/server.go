@@ -0,0 +1,277 @@
+// Package wechatpaytest 进程内的微信支付API模拟服务，用于不访问微信支付的集成测试
+//
+// 模拟服务使用自己生成的平台证书和APIv3密钥，校验请求的 Authorization，回包带有正确的 Wechatpay-* 签名，
+// 并以内存中的状态机实现订单、退款、账单、投诉及平台证书接口：
+//
+//	server := wechatpaytest.NewServer("1900009191")
+//	defer server.Close()
+//	pay := server.NewWechatPay()
+//	server.AddOrder(model.TradeQuery{OutTradeNo: "T1", TradeState: "SUCCESS", Amount: model.Amount{Total: 100}})
+//	order, err := pay.OrderQueryByOutTradeNo(ctx, "T1")
+//
+// PayOrder、SettleRefund 及 CreateComplaint 模拟用户支付、退款结果及用户投诉，
+// 并向通知地址发送加密和签名的回调通知，可以用 NotifyMux 端到端测试通知处理：
+//
+//	server.NotifyURL = app.URL
+//	err = server.PayOrder("T1")
+package wechatpaytest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/perlyna/wechatpay"
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/model"
+	"github.com/perlyna/wechatpay/util"
+)
+
+// Server 微信支付API模拟服务
+type Server struct {
+	URL                 string            // 模拟服务地址，如 http://127.0.0.1:port
+	MchID               string            // 商户号
+	APIv3Key            string            // APIv3密钥
+	MerchantPrivateKey  *rsa.PrivateKey   // 生成的商户私钥
+	MerchantCertificate *x509.Certificate // 生成的商户证书
+	PlatformPrivateKey  *rsa.PrivateKey   // 生成的平台私钥，用于回包及通知签名
+	PlatformCertificate *x509.Certificate // 生成的平台证书
+	PlatformSerialNo    string            // 平台证书序列号
+	NotifyURL           string            // 订单或退款没有通知地址时使用的通知地址
+	NotifyClient        *http.Client      // 发送回调通知使用的http客户端，为空时使用 http.DefaultClient
+
+	server *httptest.Server
+	signer *core.SHA256WithRSASigner
+	nonces *core.MemoryNonceCache
+
+	mu                   sync.Mutex
+	merchantCertificates map[string]*x509.Certificate // 商户证书序列号 -> 商户证书，用于校验请求签名
+	orders               map[string]*order            // 商户订单号 -> 订单
+	transactions         map[string]string            // 微信支付订单号 -> 商户订单号
+	refunds              map[string]*refund           // 商户退款单号 -> 退款
+	complaints           []*complaint                 // 按创建顺序排列的投诉单
+	complaintNotifyURL   string                       // 投诉通知回调地址
+	bills                map[string][]byte            // 账单下载token -> 账单文件
+	deliveries           []Delivery                   // 已发送的回调通知
+	seq                  int64                        // 生成单号的序号
+}
+
+// NewServer 创建并启动模拟服务，生成商户证书、平台证书及APIv3密钥
+func NewServer(mchID string) *Server {
+	merchantKey, merchantCertificate := fixtures.NewCertificate(mchID)
+	platformKey, platformCertificate := fixtures.NewCertificate("Tenpay.com Root CA")
+	s := &Server{
+		MchID:                mchID,
+		APIv3Key:             randomString(32),
+		MerchantPrivateKey:   merchantKey,
+		MerchantCertificate:  merchantCertificate,
+		PlatformPrivateKey:   platformKey,
+		PlatformCertificate:  platformCertificate,
+		PlatformSerialNo:     util.GetCertificateSerialNumber(platformCertificate),
+		merchantCertificates: make(map[string]*x509.Certificate),
+		orders:               make(map[string]*order),
+		transactions:         make(map[string]string),
+		refunds:              make(map[string]*refund),
+		bills:                make(map[string][]byte),
+		nonces:               core.NewMemoryNonceCache(),
+	}
+	s.signer = &core.SHA256WithRSASigner{MchCertificateSerialNo: s.PlatformSerialNo, PrivateKey: platformKey}
+	s.AddMerchantCertificate(merchantCertificate)
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close 关闭模拟服务
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client 访问模拟服务的 http client
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// AddMerchantCertificate 添加商户证书，使用该证书签名的请求可以通过校验
+func (s *Server) AddMerchantCertificate(certificate *x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.merchantCertificates[util.GetCertificateSerialNumber(certificate)] = certificate
+}
+
+// NewWechatPay 创建指向模拟服务的 WechatPay，使用生成的商户证书，并已添加平台证书
+func (s *Server) NewWechatPay() *wechatpay.WechatPay {
+	p := wechatpay.New(s.MchID, s.APIv3Key, s.MerchantPrivateKey, s.MerchantCertificate)
+	s.Configure(p)
+	return p
+}
+
+// Configure 把已有的 WechatPay 指向模拟服务并添加平台证书，商户证书需要先通过 AddMerchantCertificate 添加
+func (s *Server) Configure(p *wechatpay.WechatPay) {
+	p.SetBaseURL(s.URL, "")
+	p.Client = s.Client()
+	p.AddPlatformCertificates(s.PlatformCertificate)
+}
+
+// ServeHTTP 校验请求签名后处理请求
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, core.CodeParamError, "读取请求内容失败")
+		return
+	}
+	merchantCertificate, err := s.verifyAuthorization(r, body)
+	if err != nil {
+		s.fail(w, http.StatusUnauthorized, core.CodeSignError, err.Error())
+		return
+	}
+	s.route(w, r, body, merchantCertificate)
+}
+
+// verifyAuthorization 校验请求的 Authorization，拒绝重放的请求，返回签名使用的商户证书
+func (s *Server) verifyAuthorization(r *http.Request, body []byte) (*x509.Certificate, error) {
+	authorization, err := core.ParseAuthorization(r.Header.Get(core.Authorization))
+	if err != nil {
+		return nil, err
+	}
+	if authorization.Schema != core.SchemaSHA256RSA2048 {
+		return nil, fmt.Errorf("不支持的认证类型")
+	}
+	s.mu.Lock()
+	certificate, ok := s.merchantCertificates[authorization.SerialNo]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("商户证书序列号错误")
+	}
+	verifier := &core.AuthorizationVerifier{
+		Verifier: &core.WechatPayVerifier{Certificates: map[string]*x509.Certificate{authorization.SerialNo: certificate}},
+		MchID:    s.MchID,
+		Nonces:   s.nonces,
+	}
+	if _, err = verifier.VerifyRequest(r.Context(), r, body); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// route 按请求地址分发
+func (s *Server) route(w http.ResponseWriter, r *http.Request, body []byte, merchantCertificate *x509.Certificate) {
+	path := r.URL.Path
+	switch {
+	case path == "/v3/certificates" && r.Method == http.MethodGet:
+		s.certificates(w)
+	case strings.HasPrefix(path, "/v3/pay/transactions/"):
+		s.routeTransactions(w, r, body)
+	case path == "/v3/refund/domestic/refunds" && r.Method == http.MethodPost:
+		s.createRefund(w, body)
+	case strings.HasPrefix(path, "/v3/refund/domestic/refunds/") && r.Method == http.MethodGet:
+		s.queryRefund(w, strings.TrimPrefix(path, "/v3/refund/domestic/refunds/"))
+	case path == "/v3/bill/tradebill" && r.Method == http.MethodGet:
+		s.tradeBill(w, r)
+	case path == "/v3/bill/fundflowbill" && r.Method == http.MethodGet:
+		s.fundflowBill(w, r)
+	case path == billDownloadPath && r.Method == http.MethodGet:
+		s.downloadBill(w, r)
+	case path == "/v3/merchant-service/complaint-notifications":
+		s.complaintNotification(w, r, body)
+	case strings.HasPrefix(path, "/v3/merchant-service/complaints-v2"):
+		s.routeComplaints(w, r, body, merchantCertificate)
+	default:
+		s.fail(w, http.StatusNotFound, core.CodeResourceNotExists, "接口不存在")
+	}
+}
+
+// certificates 平台证书列表
+func (s *Server) certificates(w http.ResponseWriter) {
+	nonce := randomString(12)
+	certificatePEM := util.CertificateToPEM(s.PlatformCertificate)
+	ciphertext, err := util.EncryptToString(s.APIv3Key, "certificate", nonce, certificatePEM)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, core.CodeSystemError, err.Error())
+		return
+	}
+	info := model.CertificateInfo{
+		EffectiveTime: s.PlatformCertificate.NotBefore,
+		ExpireTime:    s.PlatformCertificate.NotAfter,
+		SerialNo:      s.PlatformSerialNo,
+	}
+	info.EncryptCertificate.Algorithm = util.AlgorithmAESGCM
+	info.EncryptCertificate.AssociatedData = "certificate"
+	info.EncryptCertificate.Nonce = nonce
+	info.EncryptCertificate.Ciphertext = ciphertext
+	s.reply(w, http.StatusOK, model.CertificateReply{Data: []model.CertificateInfo{info}})
+}
+
+// reply 返回签名的回包，v 为空时没有回包内容
+func (s *Server) reply(w http.ResponseWriter, status int, v interface{}) {
+	var body []byte
+	if v != nil {
+		var err error
+		if body, err = json.Marshal(v); err != nil {
+			status, body = http.StatusInternalServerError, []byte(`{"code":"SYSTEM_ERROR","message":"系统错误"}`)
+		}
+	}
+	if err := s.Sign(w.Header(), body); err != nil {
+		status, body = http.StatusInternalServerError, []byte(`{"code":"SYSTEM_ERROR","message":"系统错误"}`)
+	}
+	if body != nil {
+		w.Header().Set(core.ContentType, core.ApplicationJSON)
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// fail 返回错误回包
+func (s *Server) fail(w http.ResponseWriter, status int, code, message string) {
+	s.reply(w, status, map[string]string{"code": code, "message": message})
+}
+
+// Sign 使用平台私钥签名，设置 Request-Id 及 Wechatpay-* header，用于回包及回调通知
+func (s *Server) Sign(header http.Header, body []byte) error {
+	header.Set(core.RequestID, "FAKE"+strings.ToUpper(randomString(24)))
+	return signHeader(s.signer, header, body)
+}
+
+// signHeader 使用当前时间及随机串签名，设置 Wechatpay-* header
+func signHeader(signer *core.SHA256WithRSASigner, header http.Header, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := randomString(32)
+	result, err := signer.Sign(context.Background(), fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body))
+	if err != nil {
+		return err
+	}
+	header.Set(core.WechatPaySerial, signer.MchCertificateSerialNo)
+	header.Set(core.WechatPayTimestamp, timestamp)
+	header.Set(core.WechatPayNonce, nonce)
+	header.Set(core.WechatPaySignature, result.Signature)
+	return nil
+}
+
+// nextID 生成单号，prefix 后接日期及递增序号
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s%s%010d", prefix, time.Now().Format("20060102"), s.seq)
+}
+
+// randomString 生成随机字符串
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("wechatpaytest: random: %v", err))
+	}
+	for i := range b {
+		b[i] = core.Symbols[int(b[i])%len(core.Symbols)]
+	}
+	return string(b)
+}
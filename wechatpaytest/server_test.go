@@ -0,0 +1,107 @@
+package wechatpaytest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay"
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/model"
+)
+
+func TestServerOrdersAndRefunds(t *testing.T) {
+	server := NewServer("1900009191")
+	defer server.Close()
+	pay := server.NewWechatPay()
+	ctx := context.Background()
+
+	if err := pay.UpdateCertificates(); err != nil {
+		t.Fatalf("UpdateCertificates() error = %v", err)
+	}
+	server.AddOrder(model.TradeQuery{OutTradeNo: "T1", TradeState: TradeStateSuccess, SuccessTime: time.Now(),
+		Amount: model.Amount{Total: 100}})
+	order, err := pay.OrderQueryByOutTradeNo(ctx, "T1")
+	if err != nil {
+		t.Fatalf("OrderQueryByOutTradeNo() error = %v", err)
+	}
+	if order.TradeState != TradeStateSuccess || order.TransactionID == "" {
+		t.Errorf("order = %+v", order)
+	}
+	if _, err = pay.OrderQueryByOutTradeNo(ctx, "T404"); !errors.Is(err, core.ErrOrderNotExist) {
+		t.Errorf("OrderQueryByOutTradeNo() not exist error = %v", err)
+	}
+	refund, err := pay.RefundByTransactions(ctx, order.TransactionID, 30)
+	if err != nil {
+		t.Fatalf("RefundByTransactions() error = %v", err)
+	}
+	if refund.Status != RefundStatusProcessing || refund.Amount.Refund != 30 {
+		t.Errorf("refund = %+v", refund)
+	}
+	if _, err = pay.RefundByOutTradeNo(ctx, "T1", 80); !errors.Is(err, core.ErrNotEnough) {
+		t.Errorf("RefundByOutTradeNo() over refund error = %v", err)
+	}
+	if order, _ = server.Order("T1"); order.TradeState != TradeStateRefund {
+		t.Errorf("order state = %s, want REFUND", order.TradeState)
+	}
+	bill, err := pay.TradeBill(ctx, time.Now(), "ALL")
+	if err != nil {
+		t.Fatalf("TradeBill() error = %v", err)
+	}
+	if !strings.Contains(string(bill), "`T1,") {
+		t.Errorf("bill = %s", bill)
+	}
+
+	// 未在模拟服务中登记的商户证书签名的请求会被拒绝
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, otherCertificate := fixtures.NewCertificate("1900009191")
+	other := wechatpay.New("1900009191", server.APIv3Key, otherKey, otherCertificate)
+	server.Configure(other)
+	if _, err = other.OrderQueryByOutTradeNo(ctx, "T1"); !errors.Is(err, core.ErrSignError) {
+		t.Errorf("OrderQueryByOutTradeNo() with unknown certificate error = %v", err)
+	}
+}
+
+func TestServerComplaints(t *testing.T) {
+	server := NewServer("1900009191")
+	defer server.Close()
+	pay := server.NewWechatPay()
+	ctx := context.Background()
+
+	id := server.AddComplaint(model.Complaint{ComplaintDetail: "未收到商品", PayerPhone: "13800138000"})
+	complaints, err := pay.ListComplaints(ctx, time.Now().AddDate(0, 0, -1), time.Now())
+	if err != nil {
+		t.Fatalf("ListComplaints() error = %v", err)
+	}
+	if len(complaints) != 1 || complaints[0].ComplaintID != id || complaints[0].PayerPhone != "13800138000" {
+		t.Errorf("complaints = %+v", complaints)
+	}
+	if err = pay.CompleteComplaint(ctx, id); err == nil {
+		t.Errorf("CompleteComplaint() before response should fail")
+	}
+	if err = pay.ComplaintResponse(ctx, model.ComplaintResponse{ComplaintID: id, Content: "已补发"}); err != nil {
+		t.Fatalf("ComplaintResponse() error = %v", err)
+	}
+	if err = pay.CompleteComplaint(ctx, id); err != nil {
+		t.Fatalf("CompleteComplaint() error = %v", err)
+	}
+	complaint, err := pay.GetComplaint(ctx, id)
+	if err != nil || complaint.ComplaintState != ComplaintStateProcessed {
+		t.Errorf("GetComplaint() = %+v, %v", complaint, err)
+	}
+	historys, err := pay.NegotiationHistorys(ctx, id)
+	if err != nil || len(historys) != 3 {
+		t.Errorf("NegotiationHistorys() = %+v, %v", historys, err)
+	}
+	if err = pay.CreateComplaintNotification(ctx, "https://example.com/notify"); err != nil {
+		t.Fatal(err)
+	}
+	if notifyURL, err := pay.GetComplaintNotification(ctx); err != nil || notifyURL != "https://example.com/notify" {
+		t.Errorf("GetComplaintNotification() = %s, %v", notifyURL, err)
+	}
+}
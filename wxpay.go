@@ -4,10 +4,10 @@ import (
 	"context"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/perlyna/wechatpay/core"
@@ -17,79 +17,432 @@ import (
 
 // WechatPay 微信支付SDK
 type WechatPay struct {
+	mu                      sync.RWMutex                 // 保护证书表、商户私钥、解密错误收集器、授权信息生成器、中间件、时钟及APIv3密钥
 	mchID                   string                       // 微信商户号
 	apiv3Secret             string                       // 商户号 API Secret
+	apiv3Keyring            *util.Keyring                // APIv3密钥环，设置后替代 apiv3Secret 解密
 	privateKey              *rsa.PrivateKey              // 商户私钥 apiclient_key.pem
 	certificates            map[string]*x509.Certificate // 商户密钥 apiclient_cert.pem
 	certificateSerialNumber string                       // 商户密钥证书序列号
+	signer                  *core.RotatingSigner         // 商户签名器，支持商户证书轮换
+	platformCertificates    map[string]*x509.Certificate // 微信支付平台证书，用于加密敏感信息
 	credential              core.Credential              // 授权信息生成器
 	validator               core.Validator               // 签名校验相关接口
+	encryptor               core.Encryptor               // 敏感信息加密器
+	merchantKeys            map[string]*rsa.PrivateKey   // 商户证书序列号对应的商户私钥，用于解密回包中的敏感信息
+	middlewares             []core.Middleware            // 请求中间件
+	clock                   core.Clock                   // 签名、验签、选择平台证书及切换域名使用的时钟，为空时使用系统时钟
 
-	NotifyURL string       // 支付通知地址
-	Client    *http.Client // http client
+	sensitiveErrorCollector func(ctx context.Context, err *util.SensitiveFieldError) // 敏感信息解密错误收集器
+
+	NotifyURL   string            // 支付通知地址
+	Client      *http.Client      // http client
+	RetryPolicy *core.RetryPolicy // 请求重试策略，为空时不重试
+	Endpoint    *core.Endpoint    // 微信支付API域名，默认主域名不可用时切换到备用域名
+	Logger      core.Logger       // 日志，为空时不记录
+	Metrics     core.Metrics      // 监控指标，为空时不记录，可以使用 core.NewExpvarMetrics
+	Tracer      core.Tracer       // 链路追踪，为空时不记录
+
+	DebugSignature bool // 签名诊断，开启后签名相关错误为 *core.SignatureError，包含请求签名串及回包验签串
 }
 
 // New 创建微信支付模块
 func New(mchid string, apiv3Secret string, privateKey *rsa.PrivateKey, certificate *x509.Certificate) *WechatPay {
-	serialNumber := strings.ToUpper(hex.EncodeToString(certificate.SerialNumber.Bytes()))
+	serialNumber := util.GetCertificateSerialNumber(certificate)
 	signer := &core.SHA256WithRSASigner{MchCertificateSerialNo: serialNumber, PrivateKey: privateKey}
+	config := NewWithSigner(mchid, apiv3Secret, signer, certificate)
+	config.privateKey = privateKey
+	config.merchantKeys[serialNumber] = privateKey
+	return config
+}
+
+// NewWithSigner 使用自定义签名器创建微信支付模块，适用于商户私钥保存在KMS/HSM等密钥服务中的场景
+//
+// signer 可以是 core.CryptoSigner、core.RemoteSigner 等，此时商户私钥不可用，回包中有敏感信息时返回 core.ErrNoDecryptor
+//
+// apiv3Secret 不是32字节时仍可签名请求，解密回调通知及平台证书时返回错误
+func NewWithSigner(mchid string, apiv3Secret string, signer core.Signer, certificate *x509.Certificate) *WechatPay {
+	serialNumber := util.GetCertificateSerialNumber(certificate)
+	rotatingSigner, ok := signer.(*core.RotatingSigner)
+	if !ok {
+		rotatingSigner = core.NewRotatingSigner(serialNumber, signer)
+	}
 	certificates := make(map[string]*x509.Certificate)
 	certificates[serialNumber] = certificate
 	verifier := &core.WechatPayVerifier{Certificates: certificates}
+	platformCertificates := make(map[string]*x509.Certificate)
 	config := &WechatPay{
 		mchID:                   mchid,
 		apiv3Secret:             apiv3Secret,
-		privateKey:              privateKey,
 		certificates:            certificates,
 		certificateSerialNumber: serialNumber,
-		credential:              &core.WechatPayCredentials{Signer: signer, MchID: mchid},
+		platformCertificates:    platformCertificates,
+		merchantKeys:            make(map[string]*rsa.PrivateKey),
+		signer:                  rotatingSigner,
+		credential:              &core.WechatPayCredentials{Signer: rotatingSigner, MchID: mchid},
 		validator:               &core.WechatPayValidator{Verifier: verifier},
+		encryptor:               &core.WechatPayEncryptor{Certificates: platformCertificates},
 		Client:                  http.DefaultClient,
+		Endpoint:                core.DefaultEndpoint(),
 	}
 	return config
 }
 
+// SetBaseURL 设置微信支付API域名，backup 为空时不切换备用域名，如测试时指向 wechatpaytest 等模拟服务
+func (p *WechatPay) SetBaseURL(primary, backup string) {
+	endpoint := core.NewEndpoint(primary, backup)
+	if clock := p.currentClock(); clock != nil {
+		endpoint.SetClock(clock)
+	}
+	p.Endpoint = endpoint
+}
+
+// Use 添加请求中间件，先添加的中间件在最外层
+//
+// 中间件可以看到已签名的请求、回包、耗时和Request-Id，用于日志、监控、链路追踪、添加自定义header及测试桩等
+func (p *WechatPay) Use(middlewares ...core.Middleware) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 复制后替换，已创建的客户端持有的中间件列表不受影响
+	updated := make([]core.Middleware, 0, len(p.middlewares)+len(middlewares))
+	updated = append(updated, p.middlewares...)
+	p.middlewares = append(updated, middlewares...)
+}
+
+// SetClock 设置时钟，maxSkew 为时间戳允许的最大偏差，为0时为5分钟
+//
+// 时钟用于请求签名、回包及回调通知验签、商户证书切换、选择加密用的平台证书及备用域名的冷却时间；
+// 服务器时间不准时可以放宽 maxSkew，测试时可以注入固定时间回放录制的回包。
+// NotifyMux 的 Store 需要单独设置 core.MemoryNotificationStore 的 Clock
+func (p *WechatPay) SetClock(clock core.Clock, maxSkew time.Duration) {
+	p.signer.SetClock(clock)
+	if p.Endpoint != nil {
+		p.Endpoint.SetClock(clock)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = clock
+	// 复制后替换，进行中的请求继续使用原来的副本
+	if credential, ok := p.credential.(*core.WechatPayCredentials); ok {
+		updated := *credential
+		updated.Clock = clock
+		p.credential = &updated
+	}
+	if validator, ok := p.validator.(*core.WechatPayValidator); ok {
+		updated := *validator
+		updated.Clock = clock
+		updated.MaxSkew = maxSkew
+		p.validator = &updated
+	}
+	if encryptor, ok := p.encryptor.(*core.WechatPayEncryptor); ok {
+		updated := *encryptor
+		updated.Clock = clock
+		p.encryptor = &updated
+	}
+}
+
+// SetNonceSource 设置请求签名使用的随机字符串生成器，默认为 core.RandomNonce
+func (p *WechatPay) SetNonceSource(nonce core.NonceSource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if credential, ok := p.credential.(*core.WechatPayCredentials); ok {
+		updated := *credential
+		updated.Nonce = nonce
+		p.credential = &updated
+	}
+}
+
+// currentClock 返回 SetClock 设置的时钟
+func (p *WechatPay) currentClock() core.Clock {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.clock
+}
+
+// now 当前时间，未设置时钟时使用系统时钟
+func (p *WechatPay) now() time.Time {
+	if clock := p.currentClock(); clock != nil {
+		return clock.Now()
+	}
+	return time.Now()
+}
+
+// client 返回微信支付API客户端
+func (p *WechatPay) client() *core.Client {
+	p.mu.RLock()
+	credential, middlewares := p.credential, p.middlewares
+	p.mu.RUnlock()
+	client := &core.Client{
+		HTTPClient:  p.Client,
+		Credential:  credential,
+		Validator:   p.currentValidator(),
+		Encryptor:   p.currentEncryptor(),
+		Retry:       p.RetryPolicy,
+		Endpoint:    p.Endpoint,
+		Middlewares: middlewares,
+		Logger:      p.Logger,
+		Metrics:     p.Metrics,
+		Tracer:      p.Tracer,
+
+		DebugSignature: p.DebugSignature,
+	}
+	if decryptor := p.currentDecryptor(); decryptor != nil {
+		client.Decryptor = decryptor
+	}
+	return client
+}
+
+// currentValidator 返回使用当前平台证书表的签名校验器
+func (p *WechatPay) currentValidator() core.Validator {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.validator
+}
+
+// currentEncryptor 返回使用当前平台证书表的敏感信息加密器
+func (p *WechatPay) currentEncryptor() core.Encryptor {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.encryptor
+}
+
+// currentDecryptor 返回使用全部商户私钥的敏感信息解密器，没有商户私钥时返回空
+//
+// 商户证书轮换期间回包中的敏感信息可能使用新旧任一商户证书加密：当前签名证书的私钥优先，其他私钥按启用时间从新到旧依次尝试
+func (p *WechatPay) currentDecryptor() *core.WechatPayDecryptor {
+	active, serialNumbers := p.signer.ActiveSerialNo(), p.signer.SerialNos()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	decryptor := &core.WechatPayDecryptor{ErrorCollector: p.sensitiveErrorCollector}
+	for i := len(serialNumbers) - 1; i >= 0; i-- {
+		privateKey, ok := p.merchantKeys[serialNumbers[i]]
+		switch {
+		case !ok:
+		case serialNumbers[i] == active:
+			decryptor.PrivateKey = privateKey
+		default:
+			decryptor.FallbackKeys = append(decryptor.FallbackKeys, privateKey)
+		}
+	}
+	if decryptor.PrivateKey == nil {
+		if len(decryptor.FallbackKeys) == 0 {
+			return nil
+		}
+		decryptor.PrivateKey, decryptor.FallbackKeys = decryptor.FallbackKeys[0], decryptor.FallbackKeys[1:]
+	}
+	return decryptor
+}
+
+// SetSensitiveErrorCollector 设置敏感信息解密错误收集器
+//
+// 设置后回包中解密失败的字段保留密文并交给 collector 处理，不再中断请求；默认返回解密错误
+func (p *WechatPay) SetSensitiveErrorCollector(collector func(ctx context.Context, err *util.SensitiveFieldError)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sensitiveErrorCollector = collector
+}
+
+// AddMerchantCertificate 添加新的商户证书，在 activeFrom 之后使用新证书签名，返回新证书序列号
+//
+// 商户证书轮换期间新旧证书同时有效，到达切换时间后自动使用新证书签名，回包中的敏感信息使用新旧商户私钥均可解密，不需要重新创建WechatPay
+func (p *WechatPay) AddMerchantCertificate(privateKey *rsa.PrivateKey, certificate *x509.Certificate, activeFrom time.Time) string {
+	serialNumber := util.GetCertificateSerialNumber(certificate)
+	p.mu.Lock()
+	p.merchantKeys[serialNumber] = privateKey
+	p.mu.Unlock()
+	p.addCertificate(certificate, false)
+	signer := &core.SHA256WithRSASigner{MchCertificateSerialNo: serialNumber, PrivateKey: privateKey}
+	p.signer.AddSigner(serialNumber, signer, activeFrom)
+	return serialNumber
+}
+
+// AddMerchantSigner 添加新的商户证书签名器，在 activeFrom 之后使用
+func (p *WechatPay) AddMerchantSigner(serialNumber string, signer core.Signer, activeFrom time.Time) {
+	p.signer.AddSigner(serialNumber, signer, activeFrom)
+}
+
+// RemoveMerchantCertificate 移除商户证书，通常在旧证书作废后调用
+func (p *WechatPay) RemoveMerchantCertificate(serialNumber string) {
+	p.signer.RemoveSigner(serialNumber)
+	p.mu.Lock()
+	delete(p.merchantKeys, serialNumber)
+	p.mu.Unlock()
+}
+
+// MerchantSerialNumber 当前用于签名的商户证书序列号
+func (p *WechatPay) MerchantSerialNumber() string {
+	return p.signer.ActiveSerialNo()
+}
+
+// SetAPIv3Keys 设置APIv3密钥环，用于APIv3密钥重置期间同时使用新旧密钥解密
+//
+// keys 按尝试顺序排列，通常新密钥在前；每个密钥必须是32字节
+func (p *WechatPay) SetAPIv3Keys(keys ...string) error {
+	keyring, err := util.NewKeyring(keys...)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.apiv3Keyring = keyring
+	p.apiv3Secret = keyring.Primary()
+	return nil
+}
+
+// decryptResource 按 algorithm 使用APIv3密钥解密回调通知及证书下载中的加密数据
+//
+// 使用密钥环中非首个密钥解密成功时记录 WARN 日志，说明仍有使用旧APIv3密钥加密的数据
+func (p *WechatPay) decryptResource(ctx context.Context, algorithm, associatedData, nonce, ciphertext string) ([]byte, error) {
+	p.mu.RLock()
+	apiv3Secret, keyring := p.apiv3Secret, p.apiv3Keyring
+	p.mu.RUnlock()
+	if keyring == nil {
+		return util.DecryptResource(algorithm, apiv3Secret, associatedData, nonce, ciphertext)
+	}
+	plaintext, keyIndex, err := keyring.DecryptResource(algorithm, associatedData, nonce, ciphertext)
+	if err == nil && keyIndex > 0 {
+		p.log(ctx, core.LogWarn, "wechatpay resource decrypted with fallback apiv3 key",
+			"key_index", keyIndex, "associated_data", associatedData)
+	}
+	return plaintext, err
+}
+
 // UpdateCertificates 更新商户当前可用的平台证书列表
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/wechatpay/wechatpay5_1.shtml
 func (p *WechatPay) UpdateCertificates() error {
-	certs, err := core.GetCertificates(context.Background(), p.Client, p.credential)
+	ctx := context.Background()
+	certs, err := p.client().GetCertificates(ctx)
 	if err != nil {
+		p.log(ctx, core.LogError, "wechatpay certificates refresh failed", "error", err)
 		return err
 	}
 	for _, cert := range certs {
-		if cert.ExpireTime.Before(time.Now()) { // 证书已过期
+		if cert.ExpireTime.Before(p.now()) { // 证书已过期
 			continue
 		}
-		if _, ok := p.certificates[cert.SerialNo]; ok { // 证书已存在
+		if p.Metrics != nil {
+			p.Metrics.CertificateExpiry(cert.SerialNo, cert.ExpireTime)
+		}
+		if p.hasCertificate(cert.SerialNo) { // 证书已存在
 			continue
 		}
-		rawCert, err := util.DecryptToByte(p.apiv3Secret, cert.EncryptCertificate.AssociatedData,
+		rawCert, err := p.decryptResource(ctx, cert.EncryptCertificate.Algorithm, cert.EncryptCertificate.AssociatedData,
 			cert.EncryptCertificate.Nonce, cert.EncryptCertificate.Ciphertext)
 		if err != nil {
+			p.log(ctx, core.LogError, "wechatpay certificate decrypt failed", "serial", cert.SerialNo, "error", err)
 			return err
 		}
 		certificate, err := util.LoadCertificate(rawCert)
 		if err != nil {
+			p.log(ctx, core.LogError, "wechatpay certificate load failed", "serial", cert.SerialNo, "error", err)
 			return err
 		}
-		serialNumber := strings.ToUpper(hex.EncodeToString(certificate.SerialNumber.Bytes()))
-		p.certificates[serialNumber] = certificate
+		serialNumber := util.GetCertificateSerialNumber(certificate)
+		p.addCertificate(certificate, true)
+		p.log(ctx, core.LogInfo, "wechatpay certificate added", "serial", serialNumber,
+			"effective_time", cert.EffectiveTime, "expire_time", cert.ExpireTime)
 	}
 	return nil
 }
 
+// hasCertificate 是否已有序列号为 serialNumber 的平台证书
+func (p *WechatPay) hasCertificate(serialNumber string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.certificates[serialNumber]
+	return ok
+}
+
+// addCertificate 添加平台证书用于校验签名，encrypt 为 true 时同时用于加密敏感信息
+//
+// 证书表写时复制：复制后替换 validator 及 encryptor，旧的证书表不再修改，进行中的请求可以继续无锁读取
+func (p *WechatPay) addCertificate(certificate *x509.Certificate, encrypt bool) {
+	serialNumber := util.GetCertificateSerialNumber(certificate)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	certificates := make(map[string]*x509.Certificate, len(p.certificates)+1)
+	for serial, c := range p.certificates {
+		certificates[serial] = c
+	}
+	certificates[serialNumber] = certificate
+	p.certificates = certificates
+	if validator, ok := p.validator.(*core.WechatPayValidator); ok {
+		updated := *validator
+		updated.Verifier = &core.WechatPayVerifier{Certificates: certificates}
+		p.validator = &updated
+	}
+	if !encrypt {
+		return
+	}
+	platformCertificates := make(map[string]*x509.Certificate, len(p.platformCertificates)+1)
+	for serial, c := range p.platformCertificates {
+		platformCertificates[serial] = c
+	}
+	platformCertificates[serialNumber] = certificate
+	p.platformCertificates = platformCertificates
+	p.encryptor = &core.WechatPayEncryptor{Certificates: platformCertificates, Clock: p.clock}
+}
+
+// AddPlatformCertificates 添加微信支付平台证书，如从本地加载的证书
+//
+// 已过期的证书只用于校验签名，如回放历史通知，不会用于加密敏感信息
+func (p *WechatPay) AddPlatformCertificates(certificates ...*x509.Certificate) {
+	for _, certificate := range certificates {
+		valid := certificate.NotAfter.After(p.now())
+		if valid && p.Metrics != nil {
+			p.Metrics.CertificateExpiry(util.GetCertificateSerialNumber(certificate), certificate.NotAfter)
+		}
+		p.addCertificate(certificate, valid)
+	}
+}
+
+// log 记录日志，未设置 Logger 时不记录
+func (p *WechatPay) log(ctx context.Context, level core.LogLevel, msg string, keyvals ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Log(ctx, level, msg, keyvals...)
+	}
+}
+
+// startNotifySpan 开始一个回调通知处理的 span，记录商户号及通知的 Request-ID
+func (p *WechatPay) startNotifySpan(r *http.Request) (context.Context, core.Span) {
+	ctx, span := core.StartSpan(r.Context(), p.Tracer, core.SpanNotification)
+	span.SetAttribute(core.AttrMchID, p.mchID)
+	if requestID := r.Header.Get(core.RequestID); requestID != "" {
+		span.SetAttribute(core.AttrRequestID, requestID)
+	}
+	return ctx, span
+}
+
+// String 返回不包含密钥的描述信息
+func (p *WechatPay) String() string {
+	return fmt.Sprintf("WechatPay{mchID: %q, certificateSerialNumber: %q, apiv3Secret: [REDACTED], privateKey: [REDACTED]}",
+		p.mchID, p.MerchantSerialNumber())
+}
+
+// GoString 实现 fmt.GoStringer，%#v 输出时不包含密钥
+func (p *WechatPay) GoString() string {
+	return p.String()
+}
+
+// Format 实现 fmt.Formatter，任何格式输出都不包含APIv3密钥和商户私钥
+func (p *WechatPay) Format(f fmt.State, verb rune) {
+	_, _ = fmt.Fprint(f, p.String())
+}
+
 // OrderQueryByTransactions 微信支付订单号查询
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_2.shtml
 func (p *WechatPay) OrderQueryByTransactions(ctx context.Context, transactionID string) (model.TradeQuery, error) {
-	reqURL := "https://api.mch.weixin.qq.com/v3/pay/transactions/id/" + transactionID + "?mchid=" + p.mchID
-	return core.OrderQuery(ctx, p.Client, reqURL, p.credential, p.validator)
+	reqURL := "/v3/pay/transactions/id/" + transactionID + "?mchid=" + p.mchID
+	ctx = core.WithEndpoint(ctx, "/v3/pay/transactions/id/{transaction_id}")
+	return p.client().OrderQuery(ctx, reqURL)
 }
 
 // OrderQueryByOutTradeNo 商户订单号查询
 // 文档链接: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_2.shtml
 func (p *WechatPay) OrderQueryByOutTradeNo(ctx context.Context, outTradeNo string) (model.TradeQuery, error) {
-	reqURL := "https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/" + outTradeNo + "?mchid=" + p.mchID
-	return core.OrderQuery(ctx, p.Client, reqURL, p.credential, p.validator)
+	reqURL := "/v3/pay/transactions/out-trade-no/" + outTradeNo + "?mchid=" + p.mchID
+	ctx = core.WithEndpoint(ctx, "/v3/pay/transactions/out-trade-no/{out_trade_no}")
+	return p.client().OrderQuery(ctx, reqURL)
 }
 
 // RefundByTransactions 微信支付订单号申请退款
@@ -108,7 +461,7 @@ func (p *WechatPay) RefundByTransactions(ctx context.Context, transactionID stri
 	refundsReq.Amount.Currency = tradeQuery.Amount.PayerCurrency
 	refundsReq.Amount.Total = tradeQuery.Amount.Total
 	refundsReq.Amount.Refund = amount
-	return core.Refunds(ctx, p.Client, refundsReq, p.credential, p.validator)
+	return p.client().Refunds(ctx, refundsReq)
 }
 
 // RefundByOutTradeNo 商户订单号申请退款
@@ -129,5 +482,5 @@ func (p *WechatPay) RefundByOutTradeNo(ctx context.Context, outTradeNo string, a
 	refundsReq.Amount.Currency = tradeQuery.Amount.PayerCurrency
 	refundsReq.Amount.Total = tradeQuery.Amount.Total
 	refundsReq.Amount.Refund = amount
-	return core.Refunds(ctx, p.Client, refundsReq, p.credential, p.validator)
+	return p.client().Refunds(ctx, refundsReq)
 }
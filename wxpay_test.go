@@ -0,0 +1,193 @@
+package wechatpay
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perlyna/wechatpay/core"
+	"github.com/perlyna/wechatpay/fixtures"
+	"github.com/perlyna/wechatpay/util"
+)
+
+// newTestWechatPay 使用 fixtures 生成的商户密钥创建 WechatPay，并添加 kit 的平台证书
+func newTestWechatPay(t *testing.T) (*WechatPay, *fixtures.Kit) {
+	kit := fixtures.New("1900009191")
+	p := New(kit.MchID, kit.APIv3Key, kit.MerchantPrivateKey, kit.MerchantCertificate)
+	p.AddPlatformCertificates(kit.PlatformCertificate)
+	return p, kit
+}
+
+func TestWechatPayFormatRedactsSecrets(t *testing.T) {
+	p, kit := newTestWechatPay(t)
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(format, p)
+		if strings.Contains(out, kit.APIv3Key) || strings.Contains(out, "PrivateKey{") {
+			t.Errorf("fmt.Sprintf(%q) leaks secrets: %s", format, out)
+		}
+		if !strings.Contains(out, "1900009191") {
+			t.Errorf("fmt.Sprintf(%q) = %s, want mchid", format, out)
+		}
+	}
+}
+
+func TestAddMerchantCertificate(t *testing.T) {
+	p, kit := newTestWechatPay(t)
+	now := time.Now()
+	p.SetClock(core.ClockFunc(func() time.Time { return now }), 0)
+	newKey, newCertificate := fixtures.NewCertificate("1900009191")
+	serialNumber := p.AddMerchantCertificate(newKey, newCertificate, now.Add(time.Hour))
+	if !p.hasCertificate(serialNumber) {
+		t.Errorf("AddMerchantCertificate() should add the certificate")
+	}
+
+	// 切换前后回包中使用新旧任一商户证书加密的敏感信息都可以解密
+	type reply struct {
+		Old string `json:"old" wechatpay:"encrypt"`
+		New string `json:"new" wechatpay:"encrypt"`
+	}
+	for _, at := range []time.Time{now, now.Add(2 * time.Hour)} {
+		now = at
+		oldCiphertext, _ := util.EncryptOAEPWithCertificate("old", kit.MerchantCertificate)
+		newCiphertext, _ := util.EncryptOAEPWithCertificate("new", newCertificate)
+		v := &reply{Old: oldCiphertext, New: newCiphertext}
+		if err := p.client().Decryptor.Decrypt(context.Background(), v); err != nil || v.Old != "old" || v.New != "new" {
+			t.Errorf("Decrypt() at %s = %+v, %v", at, v, err)
+		}
+	}
+	if p.MerchantSerialNumber() != serialNumber || p.currentDecryptor().PrivateKey != newKey {
+		t.Errorf("after cut-over signer and decryptor should prefer the new key")
+	}
+}
+
+func TestInvalidAPIv3Key(t *testing.T) {
+	key, certificate := fixtures.NewCertificate("1900009191")
+	p := New("1900009191", "0123456789abcdef", key, certificate)
+	ciphertext, _ := util.EncryptToString("0123456789abcdef", "transaction", "0123456789ab", "{}")
+	if _, err := p.decryptResource(context.Background(), util.AlgorithmAESGCM, "transaction", "0123456789ab", ciphertext); err == nil {
+		t.Errorf("decryptResource() with 16 bytes apiv3 key should fail")
+	}
+}
+
+func TestSetAPIv3KeysLogsFallback(t *testing.T) {
+	p, _ := newTestWechatPay(t)
+	var buf bytes.Buffer
+	p.Logger = &core.StdLogger{Logger: log.New(&buf, "", 0)}
+	oldKey, newKey := p.apiv3Secret, "fedcba9876543210fedcba9876543210"
+	ciphertext, _ := util.EncryptToString(oldKey, "transaction", "0123456789ab", "{}")
+	if err := p.SetAPIv3Keys(newKey, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := p.decryptResource(context.Background(), util.AlgorithmAESGCM, "transaction", "0123456789ab", ciphertext)
+	if err != nil || string(plaintext) != "{}" {
+		t.Fatalf("decryptResource() = %s, %v", plaintext, err)
+	}
+	if !strings.Contains(buf.String(), `key_index="1"`) {
+		t.Errorf("fallback apiv3 key not logged: %s", buf.String())
+	}
+}
+
+func TestAddCertificateConcurrentWithEncrypt(t *testing.T) {
+	p, _ := newTestWechatPay(t)
+	type request struct {
+		Name string `json:"name" wechatpay:"encrypt"`
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, certificate := fixtures.NewCertificate("Tenpay.com Root CA")
+			p.addCertificate(certificate, true)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, serialNo, err := p.client().Encryptor.Encrypt(context.Background(), request{Name: "张三"}); err != nil || serialNo == "" {
+				t.Errorf("Encrypt() = %s, %v", serialNo, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(p.platformCertificates) != 5 {
+		t.Errorf("platform certificates = %d, want 5", len(p.platformCertificates))
+	}
+}
+
+func TestUseConcurrentWithClient(t *testing.T) {
+	p, _ := newTestWechatPay(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.Use(func(next core.RoundTrip) core.RoundTrip { return next })
+		}()
+		go func() {
+			defer wg.Done()
+			_ = p.client()
+		}()
+	}
+	wg.Wait()
+	if n := len(p.client().Middlewares); n != 4 {
+		t.Errorf("middlewares = %d, want 4", n)
+	}
+}
+
+func TestAddPlatformCertificatesRecordsExpiry(t *testing.T) {
+	p, _ := newTestWechatPay(t)
+	metrics, err := core.NewExpvarMetrics("wechatpay_platform_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Metrics = metrics
+	_, certificate := fixtures.NewCertificate("Tenpay.com Root CA")
+	p.AddPlatformCertificates(certificate)
+	serialNumber := util.GetCertificateSerialNumber(certificate)
+	if out := expvar.Get("wechatpay_platform_test").String(); !strings.Contains(out, serialNumber) {
+		t.Errorf("certificate expiry of %s not recorded: %s", serialNumber, out)
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	p, kit := newTestWechatPay(t)
+	now := time.Now()
+	p.SetClock(core.ClockFunc(func() time.Time { return now }), 0)
+	_, newCertificate := fixtures.NewCertificateAt("Tenpay.com Root CA", now.Add(24*time.Hour))
+	p.AddPlatformCertificates(newCertificate)
+
+	// 按注入的时钟选择加密使用的平台证书
+	type request struct {
+		Name string `json:"name" wechatpay:"encrypt"`
+	}
+	for at, want := range map[time.Time]*x509.Certificate{now: kit.PlatformCertificate, now.Add(48 * time.Hour): newCertificate} {
+		now = at
+		if _, serialNo, err := p.client().Encryptor.Encrypt(context.Background(), request{Name: "张三"}); err != nil ||
+			serialNo != util.GetCertificateSerialNumber(want) {
+			t.Errorf("Encrypt() at %s = %s, %v", at, serialNo, err)
+		}
+	}
+
+	// 设置时钟及随机字符串生成器与创建客户端并发安全
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.SetClock(core.SystemClock, 0)
+			p.SetNonceSource(core.RandomNonce)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = p.client()
+		}()
+	}
+	wg.Wait()
+}